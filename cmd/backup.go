@@ -0,0 +1,424 @@
+// Package cmd
+/*
+Copyright © 2021 NAME HERE runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/runtimeracer/kajitool/constants"
+	"github.com/runtimeracer/kajitool/query"
+	"github.com/runtimeracer/kajitool/util"
+	"github.com/spf13/cobra"
+)
+
+const (
+	backupManifestName = "manifest.json"
+	// backupSnapshotTimeFormat is used for naming snapshot files
+	backupSnapshotTimeFormat = "20060102T150405Z"
+	// backupMaxNameLength limits the part of a backup file name derived from the dataset name
+	backupMaxNameLength = 50
+)
+
+// Flags
+var fullBackup bool
+var backupDatasets []string
+
+// unsafeFileNameChars matches everything not allowed in the name part of backup file names
+var unsafeFileNameChars = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// backupCmd represents the backup command
+var backupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Downloads all datasets of the logged in user into a specified target directory.",
+	Long: `backup fetches the content of all datasets owned by the logged in user and saves each of them as a timestamped snapshot
+inside the specified target directory. Snapshots are stored in a directory per dataset, named by dataset ID and name,
+e.g. 'XXX_my_dataset/20210801T120000Z.csv'.
+
+Additional datasets can be specified using --dataset, which can be repeated. If the datasets of the user can't be listed,
+only the specified datasets and those listed in the manifest of a previous backup to the same target directory are backed up.
+
+Also writes a manifest (manifest.json) listing all datasets along with their snapshots, entry counts and last update timestamps.
+Datasets whose last update timestamp and entry count didn't change since the previous backup are skipped, unless --full is set.
+Datasets which no longer exist are kept in the manifest and marked as removed.
+If a dataset can't be downloaded, the backup continues with the remaining datasets and fails at the end.
+
+Using --meta, all metadata of each dataset including its documents is written next to its snapshot.
+
+param target: a local directory; created if not existing. Files will be saved in csv format, unless --format is set.`,
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+
+		// Sanity checks
+		if target, err = validateBackupTarget(target); err != nil {
+			return err
+		}
+
+		// Load session key from the credential store
+		if err = loadSessionKey(); err != nil {
+			return err
+		}
+
+		// Init Client
+		client, err := newSessionClient(endpoint)
+		if err != nil {
+			return err
+		}
+
+		// Login via Session key
+		loginResult := query.LoginResult{}
+		if loginResult, err = client.DoLoginAuthToken(cmd.Context(), sessionKey); err != nil {
+			return err
+		}
+
+		// Get User Info from Login result
+		userInfo := &loginResult.Login.User
+
+		// Read the state of the previous backup
+		manifestPath := filepath.Join(target, backupManifestName)
+		var previous backupManifest
+		if previous, err = readBackupManifest(manifestPath); err != nil {
+			return err
+		}
+		if previous.UserID != "" && previous.UserID != string(userInfo.ID) {
+			return fmt.Errorf("target contains a backup of user %v; use a separate directory for each user", previous.Username)
+		}
+
+		// Find all datasets of the user, along with the specified ones and those of the previous backup
+		discovered, errList := fetchUserDatasets(cmd.Context(), client, string(userInfo.ID))
+		if errList != nil {
+			if cmd.Context().Err() != nil {
+				return errList
+			}
+			fmt.Println(fmt.Sprintf("WARNING: Unable to list the datasets of user %v, backing up the specified datasets and those of the previous backup only. error: %v", userInfo.Username, errList))
+		}
+		datasetIDs := getBackupDatasetIDs(backupDatasets, discovered, previous)
+		if len(datasetIDs) == 0 {
+			if errList != nil {
+				return errors.New("no datasets to back up; specify them using --dataset, following backups to the same target include them automatically")
+			}
+			fmt.Println(fmt.Sprintf("User %v has no datasets.", userInfo.Username))
+		}
+		fmt.Println(fmt.Sprintf("Backing up %v datasets of user %v", len(datasetIDs), userInfo.Username))
+
+		// Download each changed dataset
+		manifest := backupManifest{
+			CreatedAt: time.Now().Unix(),
+			UserID:    string(userInfo.ID),
+			Username:  string(userInfo.Username),
+			Datasets:  make([]backupManifestEntry, 0, len(datasetIDs)),
+		}
+		failed, skipped := 0, 0
+		for i, datasetID := range datasetIDs {
+			entry := previous.find(datasetID)
+
+			datasetInfo, errInfo := client.GetAITrainerGroup(cmd.Context(), datasetID, sessionKey)
+			if errInfo == nil && datasetInfo.User.ID != userInfo.ID {
+				errInfo = errors.New("not your dataset! You can only back up your own datasets")
+			}
+			if errInfo == nil && bool(datasetInfo.Deleted) {
+				// Keep the snapshots of datasets which no longer exist
+				fmt.Println(fmt.Sprintf("[%v/%v] Dataset %v no longer exists, keeping its snapshots.", i+1, len(datasetIDs), datasetID))
+				entry.ID = datasetID
+				entry.Removed = true
+				manifest.Datasets = append(manifest.Datasets, entry)
+				continue
+			}
+			if errInfo != nil {
+				// Don't continue if the user interrupted the backup
+				if cmd.Context().Err() != nil {
+					return errInfo
+				}
+				fmt.Println(fmt.Sprintf("WARNING: Unable to fetch info on dataset %v: %v", datasetID, errInfo))
+				entry.ID = datasetID
+				entry.Error = errInfo.Error()
+				manifest.Datasets = append(manifest.Datasets, entry)
+				failed++
+				continue
+			}
+			fmt.Println(fmt.Sprintf("[%v/%v] Dataset %v (%v), %v indexed entries", i+1, len(datasetIDs), datasetInfo.Name, datasetInfo.ID, datasetInfo.Count))
+
+			if !fullBackup && entry.isUnchanged(datasetInfo, target) {
+				fmt.Println("Unchanged since the last backup, skipping.")
+				entry.Name = string(datasetInfo.Name)
+				manifest.Datasets = append(manifest.Datasets, entry)
+				skipped++
+				continue
+			}
+
+			if errBackup := backupDataset(cmd.Context(), client, datasetInfo, target, manifest.CreatedAt, &entry); errBackup != nil {
+				// Don't continue if the user interrupted the backup
+				if cmd.Context().Err() != nil {
+					return errBackup
+				}
+				fmt.Println(fmt.Sprintf("WARNING: Unable to backup dataset %v: %v", datasetInfo.ID, errBackup))
+				entry.Error = errBackup.Error()
+				failed++
+			}
+			manifest.Datasets = append(manifest.Datasets, entry)
+		}
+
+		// Write manifest
+		if err = writeBackupManifest(manifestPath, manifest); err != nil {
+			return err
+		}
+
+		if failed > 0 {
+			return fmt.Errorf("backup of %v out of %v datasets failed", failed, len(datasetIDs))
+		}
+		fmt.Println(fmt.Sprintf("Backup of %v datasets done, %v unchanged.", len(datasetIDs), skipped))
+		return nil
+	},
+}
+
+func init() {
+	datasetCmd.AddCommand(backupCmd)
+
+	// Flags for backup
+	backupCmd.Flags().StringArrayVar(&backupDatasets, "dataset", nil, "ID or URL of a dataset to back up; can be repeated")
+	backupCmd.Flags().BoolVar(&fullBackup, "full", false, "create a new snapshot of every dataset, even if unchanged")
+	backupCmd.Flags().BoolVar(&writeMeta, "meta", false, "also write dataset metadata and documents next to each snapshot")
+}
+
+func validateBackupTarget(target string) (string, error) {
+	if target == "" {
+		return "", errors.New("empty target")
+	}
+	if _, ok := datasetIDFromURL(target); ok {
+		return "", errors.New("target must be a local directory")
+	}
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return "", err
+	}
+
+	return target, nil
+}
+
+// backupManifest describes the content of a backup directory
+type backupManifest struct {
+	CreatedAt int64                 `json:"createdAt"`
+	UserID    string                `json:"userId"`
+	Username  string                `json:"username"`
+	Datasets  []backupManifestEntry `json:"datasets"`
+}
+
+// find returns the entry of a dataset, or an empty entry if the manifest doesn't contain the dataset
+func (m *backupManifest) find(datasetID string) backupManifestEntry {
+	for _, entry := range m.Datasets {
+		if entry.ID == datasetID {
+			return entry
+		}
+	}
+	return backupManifestEntry{}
+}
+
+// backupManifestEntry describes the backup of a single dataset; its fields describe the latest snapshot
+type backupManifestEntry struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	File string `json:"file,omitempty"`
+	// Count is the amount of indexed entries reported by the API
+	Count int `json:"count"`
+	// Entries is the amount of entries actually downloaded
+	Entries   int    `json:"entries"`
+	UpdatedAt uint64 `json:"updatedAt"`
+	// Snapshots lists all snapshots of the dataset, oldest first
+	Snapshots []backupSnapshot `json:"snapshots,omitempty"`
+	// Removed is set if the dataset no longer exists
+	Removed bool   `json:"removed,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// backupSnapshot describes the state of a dataset at the time of a backup
+type backupSnapshot struct {
+	File      string `json:"file"`
+	CreatedAt int64  `json:"createdAt"`
+	Count     int    `json:"count"`
+	Entries   int    `json:"entries"`
+	UpdatedAt uint64 `json:"updatedAt"`
+}
+
+// isUnchanged tells whether the latest snapshot is complete and matches the current state of the dataset
+func (e *backupManifestEntry) isUnchanged(datasetInfo query.AITrainerGroup, targetDir string) bool {
+	if e.File == "" || e.Error != "" || e.UpdatedAt != datasetInfo.UpdatedAt || e.Count != int(datasetInfo.Count) {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(targetDir, filepath.FromSlash(e.File)))
+	return err == nil
+}
+
+// fetchUserDatasets fetches info on all datasets owned by a user.
+// Continues as long as the result set size equals fetch limit, which means there must be another page.
+func fetchUserDatasets(ctx context.Context, client query.KajiwotoClient, userID string) (datasets []query.AITrainerGroup, err error) {
+	datasets = make([]query.AITrainerGroup, 0)
+
+	var page = 0
+	var listResult []query.AITrainerGroup
+	for limit := constants.FetchLimit; limit >= constants.FetchLimit; page++ {
+		if listResult, err = client.GetAITrainerGroupList(ctx, userID, sessionKey, constants.FetchLimit, page); err != nil {
+			return datasets, err
+		}
+		limit = len(listResult)
+		datasets = append(datasets, listResult...)
+	}
+
+	return datasets, nil
+}
+
+// getBackupDatasetIDs returns the IDs of the discovered datasets, followed by those specified and those of the previous
+// backup not contained yet
+func getBackupDatasetIDs(specified []string, discovered []query.AITrainerGroup, previous backupManifest) []string {
+	datasetIDs := make([]string, 0, len(discovered)+len(specified)+len(previous.Datasets))
+	known := make(map[string]bool)
+	for _, datasetInfo := range discovered {
+		if id := string(datasetInfo.ID); !known[id] {
+			datasetIDs = append(datasetIDs, id)
+			known[id] = true
+		}
+	}
+	for _, input := range specified {
+		if id := parseDatasetID(input); id != "" && !known[id] {
+			datasetIDs = append(datasetIDs, id)
+			known[id] = true
+		}
+	}
+	for _, entry := range previous.Datasets {
+		if !known[entry.ID] {
+			datasetIDs = append(datasetIDs, entry.ID)
+			known[entry.ID] = true
+		}
+	}
+	return datasetIDs
+}
+
+// backupDataset downloads all entries of a dataset into a new snapshot inside the target directory,
+// and records it in the manifest entry of the dataset. On failure, the entry keeps describing the previous snapshot.
+func backupDataset(ctx context.Context, client query.KajiwotoClient, datasetInfo query.AITrainerGroup, targetDir string, createdAt int64, entry *backupManifestEntry) (err error) {
+	entry.ID = string(datasetInfo.ID)
+	entry.Name = string(datasetInfo.Name)
+	entry.Removed = false
+	entry.Error = ""
+
+	var datasetContent []DatasetEntry
+	if datasetContent, _, err = fetchDatasetEntries(ctx, client, string(datasetInfo.ID)); err != nil {
+		return err
+	}
+
+	// Organize Dataset entries to place related ones next to each other
+	datasetContent = orderDatasetEntries(datasetContent)
+
+	snapshot := backupSnapshot{
+		File:      backupSnapshotName(targetDir, datasetInfo, createdAt),
+		CreatedAt: createdAt,
+		Count:     int(datasetInfo.Count),
+		Entries:   len(datasetContent),
+		UpdatedAt: datasetInfo.UpdatedAt,
+	}
+	path := filepath.Join(targetDir, filepath.FromSlash(snapshot.File))
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err = writeDatasetWithInfo(path, datasetInfo, datasetContent); err != nil {
+		return err
+	}
+	if writeMeta {
+		if err = writeDatasetMeta(path, datasetInfo); err != nil {
+			return err
+		}
+	}
+
+	// Entries of manifests without snapshots describe a single backup file
+	if len(entry.Snapshots) == 0 && entry.File != "" {
+		entry.Snapshots = append(entry.Snapshots, backupSnapshot{
+			File:      entry.File,
+			Count:     entry.Count,
+			Entries:   entry.Entries,
+			UpdatedAt: entry.UpdatedAt,
+		})
+	}
+	entry.Snapshots = append(entry.Snapshots, snapshot)
+	entry.File = snapshot.File
+	entry.Count = snapshot.Count
+	entry.Entries = snapshot.Entries
+	entry.UpdatedAt = snapshot.UpdatedAt
+	return nil
+}
+
+// backupSnapshotName creates the path of a new dataset snapshot relative to the backup directory.
+// Snapshots are grouped in a directory per dataset, e.g. 'XXX_my_dataset/20210801T120000Z.csv'.
+// A counter is appended if a snapshot with the same timestamp already exists.
+func backupSnapshotName(targetDir string, datasetInfo query.AITrainerGroup, createdAt int64) string {
+	extension := formatCSV
+	if formatName != "" {
+		extension = strings.ToLower(formatName)
+	}
+	timestamp := time.Unix(createdAt, 0).UTC().Format(backupSnapshotTimeFormat)
+
+	name := fmt.Sprintf("%v/%v.%v", backupDatasetDirName(datasetInfo), timestamp, extension)
+	for i := 2; ; i++ {
+		if _, err := os.Stat(filepath.Join(targetDir, filepath.FromSlash(name))); os.IsNotExist(err) {
+			return name
+		}
+		name = fmt.Sprintf("%v/%v-%v.%v", backupDatasetDirName(datasetInfo), timestamp, i, extension)
+	}
+}
+
+// backupDatasetDirName creates the name of the snapshot directory of a dataset from its ID and name, e.g. 'XXX_my_dataset'
+func backupDatasetDirName(datasetInfo query.AITrainerGroup) string {
+	name := strings.Trim(unsafeFileNameChars.ReplaceAllString(strings.ToLower(string(datasetInfo.Name)), "_"), "_")
+	if len(name) > backupMaxNameLength {
+		name = strings.TrimRight(name[:backupMaxNameLength], "_")
+	}
+	if name == "" {
+		return string(datasetInfo.ID)
+	}
+	return fmt.Sprintf("%v_%v", datasetInfo.ID, name)
+}
+
+// readBackupManifest reads the manifest of a backup directory. A missing file results in an empty manifest.
+func readBackupManifest(path string) (manifest backupManifest, err error) {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return manifest, nil
+	} else if err != nil {
+		return manifest, err
+	}
+	if err = json.Unmarshal(content, &manifest); err != nil {
+		return manifest, fmt.Errorf("invalid backup manifest %v: %v", path, err)
+	}
+	return manifest, nil
+}
+
+// writeBackupManifest stores the manifest of a backup directory
+func writeBackupManifest(path string, manifest backupManifest) error {
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(path, func(file io.Writer) error {
+		_, errWrite := file.Write(content)
+		return errWrite
+	})
+}
@@ -0,0 +1,197 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/runtimeracer/go-graphql-client"
+	"github.com/runtimeracer/kajitool/query"
+)
+
+func TestBackup(t *testing.T) {
+	env := newTestEnvironment(t)
+	env.addDataset("ds1", testUserID, 0, newTestEntry("", "Hello", "Hi"), newTestEntry("", "Bye", "See you"))
+	env.addDataset("ds2", testUserID, 0, newTestEntry("", "How are you?", "Fine"))
+	env.addDataset("foreign", "someone-else", 0, newTestEntry("", "Hello", "Hi"))
+
+	// All datasets of the user are discovered
+	target := env.path("backups")
+	if err := env.execute("dataset", "backup", "-t", target); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(target, backupManifestName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := backupManifest{}
+	if err = json.Unmarshal(content, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if manifest.UserID != testUserID || len(manifest.Datasets) != 2 {
+		t.Fatalf("unexpected manifest: %+v", manifest)
+	}
+
+	expected := map[string]int{"ds1": 2, "ds2": 1}
+	for _, dataset := range manifest.Datasets {
+		if dataset.Entries != expected[dataset.ID] || dataset.Count != expected[dataset.ID] {
+			t.Errorf("unexpected entry count for dataset %v: %+v", dataset.ID, dataset)
+		}
+		entries, errRead := readDataset(filepath.Join(target, dataset.File))
+		if errRead != nil {
+			t.Fatal(errRead)
+		}
+		if len(entries) != expected[dataset.ID] {
+			t.Errorf("expected %v entries in %v, got %v", expected[dataset.ID], dataset.File, len(entries))
+		}
+	}
+}
+
+func TestBackupIncremental(t *testing.T) {
+	env := newTestEnvironment(t)
+	env.addDataset("ds1", testUserID, 0, newTestEntry("", "Hello", "Hi"))
+	env.addDataset("ds2", testUserID, 0, newTestEntry("", "How are you?", "Fine"))
+
+	target := env.path("backups")
+	for i := 0; i < 2; i++ {
+		if err := env.execute("dataset", "backup", "-t", target); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Unchanged datasets are skipped
+	manifest, err := readBackupManifest(filepath.Join(target, backupManifestName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshots := len(manifest.find("ds1").Snapshots); snapshots != 1 {
+		t.Fatalf("expected a single snapshot of the unchanged dataset, got %v", snapshots)
+	}
+
+	// Change one dataset and remove the other one
+	if _, err = env.client.DoTrainDataset(context.Background(), "ds1", env.sessionKey, []query.AITraining{
+		{UserMessage: "Bye", Message: "See you", Condition: "##0020##0##0"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	state := env.client.State()
+	for i, dataset := range state.Datasets {
+		if dataset.Info.ID == "ds2" {
+			state.Datasets[i].Info.Deleted = true
+		}
+	}
+	env.client.SetState(state)
+
+	if err := env.execute("dataset", "backup", "-t", target); err != nil {
+		t.Fatal(err)
+	}
+
+	if manifest, err = readBackupManifest(filepath.Join(target, backupManifestName)); err != nil {
+		t.Fatal(err)
+	}
+	changed := manifest.find("ds1")
+	if len(changed.Snapshots) != 2 || changed.Entries != 2 || changed.File != changed.Snapshots[1].File {
+		t.Errorf("expected a second snapshot of the changed dataset: %+v", changed)
+	}
+	entries, err := readDataset(filepath.Join(target, changed.Snapshots[0].File))
+	if err != nil || len(entries) != 1 {
+		t.Errorf("expected the previous snapshot to be kept, got %v entries: %v", len(entries), err)
+	}
+	if removed := manifest.find("ds2"); !removed.Removed || len(removed.Snapshots) != 1 {
+		t.Errorf("expected the removed dataset to be kept: %+v", removed)
+	}
+}
+
+func TestBackupWithoutDatasetList(t *testing.T) {
+	env := newTestEnvironment(t)
+	env.addDataset("ds1", testUserID, 0, newTestEntry("", "Hello", "Hi"))
+	env.addDataset("ds2", testUserID, 0, newTestEntry("", "How are you?", "Fine"))
+	env.client.RejectTrainerGroupList = true
+
+	target := env.path("backups")
+	if err := env.execute("dataset", "backup", "-t", target); err == nil {
+		t.Fatal("expected backup without datasets to fail")
+	}
+
+	// Falls back to the specified datasets and those of the previous backup
+	if err := env.execute("dataset", "backup", "-t", target, "--dataset", "ds1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.execute("dataset", "backup", "-t", target, "--dataset", "ds2"); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := readBackupManifest(filepath.Join(target, backupManifestName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Datasets) != 2 || manifest.find("ds1").Entries != 1 || manifest.find("ds2").Entries != 1 {
+		t.Errorf("expected both datasets to be backed up: %+v", manifest.Datasets)
+	}
+}
+
+func TestBackupForeignDataset(t *testing.T) {
+	env := newTestEnvironment(t)
+	env.addDataset("ds1", testUserID, 0, newTestEntry("", "Hello", "Hi"))
+	env.addDataset("foreign", "someone-else", 0, newTestEntry("", "Hello", "Hi"))
+
+	// The remaining datasets are backed up anyway
+	target := env.path("backups")
+	if err := env.execute("dataset", "backup", "-t", target, "--dataset", "ds1", "--dataset", "foreign", "--dataset", "missing"); err == nil {
+		t.Fatal("expected backup of foreign and missing datasets to fail")
+	}
+
+	manifest, err := readBackupManifest(filepath.Join(target, backupManifestName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backedUp := manifest.find("ds1"); backedUp.Error != "" || backedUp.Entries != 1 {
+		t.Errorf("expected own dataset to be backed up: %+v", backedUp)
+	}
+	for _, id := range []string{"foreign", "missing"} {
+		if failed := manifest.find(id); failed.Error == "" || failed.File != "" {
+			t.Errorf("expected an error for dataset %v: %+v", id, failed)
+		}
+	}
+}
+
+func TestBackupOtherUser(t *testing.T) {
+	env := newTestEnvironment(t)
+	env.addDataset("ds1", testUserID, 0, newTestEntry("", "Hello", "Hi"))
+
+	target := env.path("backups")
+	if err := os.MkdirAll(target, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeBackupManifest(filepath.Join(target, backupManifestName), backupManifest{UserID: "someone-else", Username: "someone"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.execute("dataset", "backup", "-t", target, "--dataset", "ds1"); err == nil {
+		t.Fatal("expected backup into the directory of another user to fail")
+	}
+}
+
+func TestGetBackupDatasetIDs(t *testing.T) {
+	previous := backupManifest{Datasets: []backupManifestEntry{{ID: "ds2"}, {ID: "ds3"}, {ID: "ds4"}}}
+	discovered := []query.AITrainerGroup{{ID: "ds0"}, {ID: "ds3"}}
+	got := getBackupDatasetIDs([]string{"ds1", "https://kajiwoto.com/d/ds2", "ds1"}, discovered, previous)
+	if want := []string{"ds0", "ds3", "ds1", "ds2", "ds4"}; !equalStrings(got, want) {
+		t.Errorf("expected dataset IDs %v, got %v", want, got)
+	}
+}
+
+func TestBackupDatasetDirName(t *testing.T) {
+	tests := map[string]string{
+		"My Dataset!":   "ds1_my_dataset",
+		"  ../../etc  ": "ds1_etc",
+		"???":           "ds1",
+	}
+	for name, expected := range tests {
+		if dirName := backupDatasetDirName(query.AITrainerGroup{ID: "ds1", Name: graphql.String(name)}); dirName != expected {
+			t.Errorf("expected directory name %q for %q, got %q", expected, name, dirName)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+// Package cmd
+/*
+Copyright © 2021 NAME HERE runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// conditionLength is the amount of digits of a condition string
+const conditionLength = 5
+
+// Daytime is the time of day a dataset entry applies to
+type Daytime int
+
+// Daytime keys. Single conditions are 1-5, combined conditions 7-9.
+const (
+	DaytimeAny Daytime = iota
+	DaytimeEarlyMorning
+	DaytimeMorning
+	DaytimeAfternoon
+	DaytimeEvening
+	DaytimeMiddleOfSleep
+	DaytimeUnknown // -- NOT USED --
+	DaytimeEarlyMorningTillMorning
+	DaytimeEveningTillMiddleOfSleep
+	DaytimeMorningTillAfternoon
+)
+
+// LastSeen is the time since the user has been seen last, a dataset entry applies to
+type LastSeen int
+
+// LastSeen keys
+const (
+	LastSeenAny LastSeen = iota
+	LastSeen2HoursAgo
+	LastSeen12HoursAgo
+	LastSeen5DaysAgo
+	LastSeen5PlusDaysAgo
+)
+
+// Attachment is the attachment of the Kaji to the user, a dataset entry applies to
+type Attachment int
+
+// Attachment keys
+const (
+	AttachmentNone Attachment = iota // -- NOT USED --
+	AttachmentDisliked
+	AttachmentAny
+	AttachmentLiked
+	AttachmentUnknown // -- NOT USED --
+	AttachmentDislikedNeutral
+)
+
+// Condition of a dataset entry.
+//
+// Seems to be inspired by linux permissions: Kajiwoto stores it as a string of five digits, in the order
+// daytime, last seen, attachment; the last two digits seem to be never used (yet), but are kept as they are.
+// E.g. '71300' => Early Morning AM - Morning, Seen 2 hrs ago, Liked.
+type Condition struct {
+	Daytime    Daytime
+	LastSeen   LastSeen
+	Attachment Attachment
+	Reserved   [2]int
+}
+
+// ParseCondition parses a condition string of five digits, e.g. '00200'
+func ParseCondition(value string) (Condition, error) {
+	if len(value) != conditionLength {
+		return Condition{}, fmt.Errorf("invalid condition '%v', must be %v digits", value, conditionLength)
+	}
+	digits := make([]int, conditionLength)
+	for i, char := range []byte(value) {
+		if char < '0' || char > '9' {
+			return Condition{}, fmt.Errorf("invalid condition '%v', must be %v digits", value, conditionLength)
+		}
+		digits[i] = int(char - '0')
+	}
+
+	condition := Condition{
+		Daytime:    Daytime(digits[0]),
+		LastSeen:   LastSeen(digits[1]),
+		Attachment: Attachment(digits[2]),
+		Reserved:   [2]int{digits[3], digits[4]},
+	}
+	if err := condition.Validate(); err != nil {
+		return Condition{}, fmt.Errorf("invalid condition '%v': %v", value, err)
+	}
+	return condition, nil
+}
+
+// ParseConditionNames creates a condition from the readable names of its components, as stored in CSV and JSON files
+func ParseConditionNames(daytime, lastSeen, attachment string) (Condition, error) {
+	var condition Condition
+	var err error
+	if condition.Daytime, err = parseDaytimeName(daytime); err != nil {
+		return Condition{}, err
+	}
+	if condition.LastSeen, err = parseLastSeenName(lastSeen); err != nil {
+		return Condition{}, err
+	}
+	if condition.Attachment, err = parseAttachmentName(attachment); err != nil {
+		return Condition{}, err
+	}
+	return condition, nil
+}
+
+// String formats the condition as string of five digits, as used by the API
+func (c Condition) String() string {
+	return fmt.Sprintf("%v%v%v%v%v", int(c.Daytime), int(c.LastSeen), int(c.Attachment), c.Reserved[0], c.Reserved[1])
+}
+
+// Validate checks whether all components of the condition are known
+func (c Condition) Validate() error {
+	if !c.Daytime.Valid() {
+		return fmt.Errorf("invalid daytime key %v", int(c.Daytime))
+	}
+	if !c.LastSeen.Valid() {
+		return fmt.Errorf("invalid last seen key %v", int(c.LastSeen))
+	}
+	if !c.Attachment.Valid() {
+		return fmt.Errorf("invalid attachment key %v", int(c.Attachment))
+	}
+	for _, digit := range c.Reserved {
+		if digit < 0 || digit > 9 {
+			return fmt.Errorf("invalid reserved digit %v", digit)
+		}
+	}
+	return nil
+}
+
+// MarshalJSON stores the condition as string of five digits
+func (c Condition) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.String())
+}
+
+// UnmarshalJSON reads a condition stored as string of five digits
+func (c *Condition) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	condition, err := ParseCondition(value)
+	if err != nil {
+		return err
+	}
+	*c = condition
+	return nil
+}
+
+// Key returns the digit of the daytime in a condition string
+func (d Daytime) Key() string {
+	return strconv.Itoa(int(d))
+}
+
+// Name returns the readable name of the daytime
+func (d Daytime) Name() string {
+	return daytimeMap[d.Key()]
+}
+
+// Valid checks whether the daytime is known
+func (d Daytime) Valid() bool {
+	_, ok := daytimeMap[d.Key()]
+	return ok
+}
+
+// Key returns the digit of the last seen value in a condition string
+func (l LastSeen) Key() string {
+	return strconv.Itoa(int(l))
+}
+
+// Name returns the readable name of the last seen value
+func (l LastSeen) Name() string {
+	return lastSeenMap[l.Key()]
+}
+
+// Valid checks whether the last seen value is known
+func (l LastSeen) Valid() bool {
+	_, ok := lastSeenMap[l.Key()]
+	return ok
+}
+
+// Key returns the digit of the attachment in a condition string
+func (a Attachment) Key() string {
+	return strconv.Itoa(int(a))
+}
+
+// Name returns the readable name of the attachment
+func (a Attachment) Name() string {
+	return attachmentMap[a.Key()]
+}
+
+// Valid checks whether the attachment is known
+func (a Attachment) Valid() bool {
+	_, ok := attachmentMap[a.Key()]
+	return ok
+}
+
+func parseDaytimeName(name string) (Daytime, error) {
+	key, err := lookupConditionName(daytimeMap, name, "daytime")
+	return Daytime(key), err
+}
+
+func parseLastSeenName(name string) (LastSeen, error) {
+	key, err := lookupConditionName(lastSeenMap, name, "last seen")
+	return LastSeen(key), err
+}
+
+func parseAttachmentName(name string) (Attachment, error) {
+	key, err := lookupConditionName(attachmentMap, name, "attachment")
+	return Attachment(key), err
+}
+
+// lookupConditionName returns the digit of a readable condition component name
+func lookupConditionName(names map[string]string, name, component string) (int, error) {
+	for key, value := range names {
+		if value == name {
+			return strconv.Atoi(key)
+		}
+	}
+	return 0, fmt.Errorf("invalid %v key %v", component, name)
+}
@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseCondition(t *testing.T) {
+	condition, err := ParseCondition("71300")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Condition{Daytime: DaytimeEarlyMorningTillMorning, LastSeen: LastSeen2HoursAgo, Attachment: AttachmentLiked}
+	if condition != want {
+		t.Errorf("expected %+v, got %+v", want, condition)
+	}
+	if condition.String() != "71300" {
+		t.Errorf("expected '71300', got %q", condition.String())
+	}
+	if condition.Daytime.Name() != "daytime_early_morning_till_morning" || condition.LastSeen.Name() != "seen_2_hrs_ago" || condition.Attachment.Name() != "attachment_liked" {
+		t.Errorf("unexpected names %v, %v, %v", condition.Daytime.Name(), condition.LastSeen.Name(), condition.Attachment.Name())
+	}
+
+	// Reserved digits are kept as they are
+	if condition, err = ParseCondition("00212"); err != nil || condition.String() != "00212" {
+		t.Errorf("expected reserved digits to be kept, got %q, %v", condition.String(), err)
+	}
+
+	for _, invalid := range []string{"", "0", "0020", "002000", "0a200", "EMPTY", "05200", "00600", "-0200"} {
+		if _, err = ParseCondition(invalid); err == nil {
+			t.Errorf("expected error parsing %q", invalid)
+		}
+	}
+}
+
+func TestParseConditionNames(t *testing.T) {
+	condition, err := ParseConditionNames("daytime_evening", "seen_5_days_ago", "attachment_disliked_neutral")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if condition.String() != "43500" {
+		t.Errorf("expected '43500', got %q", condition.String())
+	}
+	if _, err = ParseConditionNames("EMPTY", "seen_any", "attachment_any"); err == nil {
+		t.Error("expected error on invalid daytime name")
+	}
+	if _, err = ParseConditionNames("daytime_any", "seen_any", ""); err == nil {
+		t.Error("expected error on empty attachment name")
+	}
+}
+
+func TestConditionValidate(t *testing.T) {
+	if err := (Condition{}).Validate(); err != nil {
+		t.Errorf("expected zero condition to be valid, got %v", err)
+	}
+	for _, invalid := range []Condition{
+		{Daytime: 10},
+		{LastSeen: 5},
+		{Attachment: -1},
+		{Reserved: [2]int{0, 10}},
+	} {
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("expected %+v to be invalid", invalid)
+		}
+	}
+}
+
+func TestConditionJSON(t *testing.T) {
+	// Conditions are stored as digits, e.g. in sync bases
+	entry := newTestEntry("abc", "Hello", "Hi")
+	content, err := json.Marshal(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result DatasetEntry
+	if err = json.Unmarshal(content, &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Condition != entry.Condition {
+		t.Errorf("expected condition %v, got %v", entry.Condition, result.Condition)
+	}
+	if err = json.Unmarshal([]byte(`{"Condition":"0"}`), &result); err == nil {
+		t.Error("expected error on malformed condition")
+	}
+}
+
+func TestFromCSVInvalidCondition(t *testing.T) {
+	entry := newTestEntry("abc", "Hello", "Hi")
+	record := entry.ToCSV()
+	record[5] = emptyColumn
+
+	converter := &DatasetEntry{}
+	if _, err := converter.FromCSV(record); err == nil {
+		t.Error("expected error on invalid daytime")
+	}
+}
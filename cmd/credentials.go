@@ -0,0 +1,198 @@
+// Package cmd
+/*
+Copyright © 2021 NAME HERE runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/mitchellh/go-homedir"
+	"github.com/runtimeracer/kajitool/constants"
+	"github.com/runtimeracer/kajitool/credentials"
+	"github.com/spf13/viper"
+	"golang.org/x/term"
+)
+
+const (
+	// credentialsConfigKey holds the reference to the stored session key of a profile in the config file
+	credentialsConfigKey = "credentials"
+	// legacySessionKeyConfigKey holds the plaintext session key stored in the config file by older versions
+	legacySessionKeyConfigKey = "sessionkey"
+	// credentialsFileName is the default name of the encrypted credentials file inside the home directory
+	credentialsFileName = ".kajitool.credentials"
+)
+
+// passphraseEnv can hold the passphrase of the encrypted credentials file, e.g. for scheduled jobs
+var passphraseEnv = constants.EnvPrefix + "_PASSPHRASE"
+
+// Flags
+var credentialStore, credentialsFile string
+
+// readPassphrase returns the passphrase of the encrypted credentials file, either from the environment
+// or by prompting for it without echoing the input.
+var readPassphrase = func() ([]byte, error) {
+	if passphrase, ok := os.LookupEnv(passphraseEnv); ok {
+		return []byte(passphrase), nil
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return nil, fmt.Errorf("no passphrase for the credentials file: set %v or run kajitool in a terminal", passphraseEnv)
+	}
+	fmt.Fprint(os.Stderr, "Passphrase for the credentials file: ")
+	passphrase, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	return passphrase, err
+}
+
+// openCredentialStore opens the credential store of the specified backend
+func openCredentialStore(backend string) (credentials.Store, error) {
+	switch backend {
+	case credentials.BackendFile:
+		path, err := credentialsFilePath()
+		if err != nil {
+			return nil, err
+		}
+		return credentials.NewFileStore(path, readPassphrase), nil
+	case credentials.BackendKeyring:
+		return credentials.NewKeyringStore(constants.KajiToolName), nil
+	}
+	return nil, credentials.ValidateBackend(backend)
+}
+
+// credentialsFilePath returns the path of the encrypted credentials file, placed next to the home directory by default
+func credentialsFilePath() (string, error) {
+	if credentialsFile != "" {
+		return credentialsFile, nil
+	}
+	home, err := homedir.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, credentialsFileName), nil
+}
+
+// loadSessionKey reads the session key of the current profile from the credential store referenced in the config file,
+// unless it has been provided manually via --sessionkey.
+func loadSessionKey() error {
+	if sessionKey != "" {
+		return nil
+	}
+	name, err := validateProfileName(currentProfile())
+	if err != nil {
+		return err
+	}
+	if name == defaultProfile {
+		if err = migrateLegacySessionKey(); err != nil {
+			return err
+		}
+		// The session key may still be set in the environment
+		if sessionKey = viper.GetString(legacySessionKeyConfigKey); sessionKey != "" {
+			return nil
+		}
+	}
+	current, ok := loadProfile(name)
+	if !ok {
+		if name != defaultProfile {
+			return fmt.Errorf("profile %q not found, please login using --profile %v", name, name)
+		}
+		return nil
+	}
+
+	reference, err := credentials.ParseReference(current.Credentials)
+	if err != nil {
+		return err
+	}
+	store, err := openCredentialStore(reference.Backend)
+	if err != nil {
+		return err
+	}
+	if sessionKey, err = store.Get(reference.Account); errors.Is(err, credentials.ErrNotFound) {
+		return fmt.Errorf("no session key stored for profile %v, please login again", name)
+	}
+	return err
+}
+
+// migrateLegacySessionKey moves the plaintext session key stored in the config file by older versions into the
+// credential store of the default profile, and removes it from the config file.
+// If the default profile already has a stored session key, the plaintext one is outdated and just removed.
+func migrateLegacySessionKey() error {
+	if !viper.InConfig(legacySessionKeyConfigKey) {
+		return nil
+	}
+	config, err := readConfigFile(viper.ConfigFileUsed())
+	if err != nil {
+		return err
+	}
+	legacyKey, _ := config[legacySessionKeyConfigKey].(string)
+	if _, ok := loadProfile(defaultProfile); ok || legacyKey == "" {
+		return updateConfig(func(config map[string]interface{}) error {
+			delete(config, legacySessionKeyConfigKey)
+			return nil
+		})
+	}
+
+	migrated, err := storeSessionKey("", legacyKey)
+	if err != nil {
+		return fmt.Errorf("unable to move the session key of the config file into the credential store: %w", err)
+	}
+	fmt.Println(fmt.Sprintf("Moved the session key of the config file into the credential store: %v", migrated.Credentials))
+	return nil
+}
+
+// storeSessionKey saves the session key of the logged in user in the selected credential store,
+// and stores the current profile along with a reference to it in the config file.
+func storeSessionKey(username, key string) (profile, error) {
+	name, err := validateProfileName(currentProfile())
+	if err != nil {
+		return profile{}, err
+	}
+	reference := credentials.Reference{Backend: credentialStore, Account: name}
+	if err = credentials.ValidateBackend(reference.Backend); err != nil {
+		return profile{}, err
+	}
+	store, err := openCredentialStore(reference.Backend)
+	if err != nil {
+		return profile{}, err
+	}
+	if err = store.Set(reference.Account, key); err != nil {
+		return profile{}, err
+	}
+
+	// Remove the session key of a previous login of the profile, if it was stored somewhere else
+	previous, _ := loadProfile(name)
+	if previousReference, errPrevious := credentials.ParseReference(previous.Credentials); errPrevious == nil && previousReference != reference {
+		previousStore := store
+		if previousReference.Backend != reference.Backend {
+			previousStore, err = openCredentialStore(previousReference.Backend)
+		}
+		if err == nil {
+			err = previousStore.Delete(previousReference.Account)
+		}
+		if err != nil {
+			fmt.Println(fmt.Sprintf("WARNING: Unable to remove previous session key of %v: %v", previousReference, err))
+		}
+	}
+
+	current := profile{
+		Name:        name,
+		Username:    username,
+		Endpoint:    endpoint,
+		Credentials: reference.String(),
+	}
+	return current, saveProfile(current)
+}
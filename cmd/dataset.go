@@ -17,24 +17,33 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
 	"encoding/csv"
 	"fmt"
+	"io"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/paulrosania/go-charset/charset"
 	_ "github.com/paulrosania/go-charset/data"
 	"github.com/runtimeracer/go-graphql-client"
 	"github.com/runtimeracer/kajitool/constants"
 	"github.com/runtimeracer/kajitool/query"
+	"github.com/runtimeracer/kajitool/util"
 	"github.com/spf13/cobra"
 )
 
 const (
 	csvSize     = 10
 	emptyColumn = "EMPTY"
+
+	datasetURLHost       = "kajiwoto.com"
+	datasetURLPathPrefix = "/d/"
 )
 
 var (
@@ -87,7 +96,7 @@ var (
 )
 
 // Flags
-var source, target string
+var source, target, formatName string
 
 // datasetCmd represents the dataset command
 var datasetCmd = &cobra.Command{
@@ -107,12 +116,8 @@ func init() {
 	// Flags fir dataset commands
 	datasetCmd.PersistentFlags().StringVarP(&source, "source", "s", "", "source file or URL")
 	datasetCmd.PersistentFlags().StringVarP(&target, "target", "t", "", "target file or URL")
-	if err := datasetCmd.MarkPersistentFlagRequired("source"); err != nil {
-		fmt.Println(err.Error())
-	}
-	if err := datasetCmd.MarkPersistentFlagRequired("target"); err != nil {
-		fmt.Println(err.Error())
-	}
+	datasetCmd.PersistentFlags().StringVar(&formatName, "format", "", "format of local files (csv, json, jsonl, sqlite); determined by file extension if not set")
+	// Source and target are not required by all commands (e.g. backup, info); commands using them validate them themselves
 
 }
 
@@ -170,7 +175,7 @@ type DatasetEntry struct {
 	X 3 XXX Seen 5 days ago
 	X 4 XXX Seen 5 days+ ago
 	*/
-	Condition string
+	Condition Condition
 	Deleted   bool
 	// History contains possible preceding user dialogues
 	History      []string
@@ -201,11 +206,10 @@ func (e *DatasetEntry) ToCSV() []string {
 	result[2] = e.Message
 	result[3] = asmMap[e.ASM]
 
-	// Condition Split
-	conditionVars := strings.Split(e.Condition, "")
-	result[4] = attachmentMap[conditionVars[2]] // Attachment
-	result[5] = daytimeMap[conditionVars[0]]    // Daytime
-	result[6] = lastSeenMap[conditionVars[1]]   // Last seen
+	// Condition components
+	result[4] = e.Condition.Attachment.Name()
+	result[5] = e.Condition.Daytime.Name()
+	result[6] = e.Condition.LastSeen.Name()
 
 	result[7] = strconv.FormatBool(e.Deleted)
 	result[8] = strings.Join(e.History, constants.CSVListSeparator)
@@ -242,54 +246,20 @@ func (e *DatasetEntry) FromCSV(src []string) (DatasetEntry, error) {
 		return DatasetEntry{}, fmt.Errorf("invalid length, must be %v", csvSize)
 	}
 
-	// Replace condition values with proper keys
-	var foundEmotion, foundAttachment, foundDaytime, foundLastSeen bool
-	for key, val := range asmMap {
-		if val == src[3] {
-			src[3] = key
-			foundEmotion = true
-			break
-		}
-	}
-	for key, val := range attachmentMap {
-		if val == src[4] {
-			src[4] = key
-			foundAttachment = true
-			break
-		}
-	}
-	for key, val := range daytimeMap {
-		if val == src[5] {
-			src[5] = key
-			foundDaytime = true
-			break
-		}
-	}
-	for key, val := range lastSeenMap {
-		if val == src[6] {
-			src[6] = key
-			foundLastSeen = true
-			break
-		}
-	}
-	if !foundEmotion {
-		fmt.Println(fmt.Sprintf("WARNING: Invalid emotional key %v for dataset entry '%v'!", src[3], src[0]))
-	}
-	if !foundAttachment {
-		fmt.Println(fmt.Sprintf("WARNING: Invalid attachment key %v for dataset entry '%v'!", src[4], src[0]))
-	}
-	if !foundDaytime {
-		fmt.Println(fmt.Sprintf("WARNING: Invalid daytime key %v for dataset entry '%v'!", src[5], src[0]))
-	}
-	if !foundLastSeen {
-		fmt.Println(fmt.Sprintf("WARNING: Invalid last seen key %v for dataset entry '%v'!", src[6], src[0]))
+	// Replace emotion value with proper key
+	asm, errASM := lookupMapKey(asmMap, src[3], "emotional", src[0])
+	if errASM != nil {
+		return DatasetEntry{}, errASM
 	}
 
 	// Convert from Array elements
-	condition := strings.Join([]string{src[5], src[6], src[4], "00"}, "")
+	condition, errCondition := ParseConditionNames(src[5], src[6], src[4])
+	if errCondition != nil {
+		return DatasetEntry{}, fmt.Errorf("%v for dataset entry '%v'", errCondition, src[0])
+	}
 	deleted, errParse := strconv.ParseBool(src[7])
 	if errParse != nil {
-		fmt.Println("WARNING: Error Parsing Bool from String!")
+		return DatasetEntry{}, fmt.Errorf("invalid deleted flag %v for dataset entry '%v'", src[7], src[0])
 	}
 
 	var history, duplicateIDs []string
@@ -306,7 +276,7 @@ func (e *DatasetEntry) FromCSV(src []string) (DatasetEntry, error) {
 		ID:           src[0],
 		UserMessage:  src[1],
 		Message:      src[2],
-		ASM:          src[3],
+		ASM:          asm,
 		Condition:    condition,
 		Deleted:      deleted,
 		History:      history,
@@ -315,23 +285,37 @@ func (e *DatasetEntry) FromCSV(src []string) (DatasetEntry, error) {
 	return entry, nil
 }
 
-func (e *DatasetEntry) FromAITrained(src query.AITrained) DatasetEntry {
+func (e *DatasetEntry) FromAITrained(src query.AITrained) (DatasetEntry, error) {
 	// Convert from History Element
 	history := make([]string, len(src.History))
 	for i, itm := range src.History {
 		history[i] = string(itm)
 	}
 
+	// Entries without emotion are trained using an empty emotional key
+	asm := string(src.ASM)
+	if asm == "" {
+		asm = "none"
+	}
+	if _, ok := asmMap[asm]; !ok {
+		return DatasetEntry{}, fmt.Errorf("invalid emotional key %v for dataset entry '%v'", src.ASM, src.ID)
+	}
+
+	condition, errCondition := ParseCondition(string(src.Condition))
+	if errCondition != nil {
+		return DatasetEntry{}, fmt.Errorf("%v for dataset entry '%v'", errCondition, src.ID)
+	}
+
 	return DatasetEntry{
 		ID:           string(src.ID),
 		UserMessage:  string(src.UserMessage),
 		Message:      string(src.Message),
-		ASM:          string(src.ASM),
-		Condition:    string(src.Condition),
+		ASM:          asm,
+		Condition:    condition,
 		Deleted:      bool(src.Deleted),
 		History:      history,
 		DuplicateIDs: make([]string, 0),
-	}
+	}, nil
 }
 
 func (e *DatasetEntry) ToAITraining(index int) query.AITraining {
@@ -341,14 +325,9 @@ func (e *DatasetEntry) ToAITraining(index int) query.AITraining {
 		asm = e.ASM
 	}
 
-	// Condition Split
-	conditionVars := strings.Split(e.Condition, "")
-	daytime := conditionVars[0]
-	lastSeen := conditionVars[1]
-	attachment := conditionVars[2]
-
 	// Convert conditions to Form submit String
-	conditionSubmitString := fmt.Sprintf("%v##%v%v%v0##%v##0", asm, daytime, lastSeen, attachment, index)
+	condition := e.Condition
+	conditionSubmitString := fmt.Sprintf("%v##%v%v%v0##%v##0", asm, condition.Daytime.Key(), condition.LastSeen.Key(), condition.Attachment.Key(), index)
 
 	return query.AITraining{
 		UserMessage: graphql.String(e.UserMessage),
@@ -362,7 +341,7 @@ func (e *DatasetEntry) isDuplicate(c *DatasetEntry) bool {
 		e.UserMessage == c.UserMessage &&
 		e.ASM == c.ASM &&
 		e.Condition == c.Condition &&
-		cmp.Equal(e.History, c.History) {
+		cmp.Equal(e.History, c.History, cmpopts.EquateEmpty()) {
 		return true
 	}
 	return false
@@ -376,9 +355,13 @@ func readInDatasetEntry(store []DatasetEntry, entry DatasetEntry) []DatasetEntry
 		ref := &compare
 		if ref.isDuplicate(&entry) {
 			fmt.Println(fmt.Sprintf("Warning: Dataset Entries %v and %v are identical!", compare.ID, entry.ID))
-			// Mark them as duplicates for each other
-			store[i].DuplicateIDs = append(compare.DuplicateIDs, entry.ID)
-			entry.DuplicateIDs = append(entry.DuplicateIDs, compare.ID)
+			// Mark them as duplicates for each other, unless already marked
+			if !containsString(compare.DuplicateIDs, entry.ID) {
+				store[i].DuplicateIDs = append(compare.DuplicateIDs, entry.ID)
+			}
+			if !containsString(entry.DuplicateIDs, compare.ID) {
+				entry.DuplicateIDs = append(entry.DuplicateIDs, compare.ID)
+			}
 		}
 	}
 
@@ -387,33 +370,34 @@ func readInDatasetEntry(store []DatasetEntry, entry DatasetEntry) []DatasetEntry
 	return store
 }
 
-// writeCSV
-func writeCSV(target string, entries []DatasetEntry) error {
-
-	csvfile, err := os.Create(target)
-	if err != nil {
-		return err
+// containsString tells whether list contains value
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
 	}
+	return false
+}
 
-	// write lines - Convert and store as UTF-8
-	inputWriter, err := charset.NewWriter("utf-8", csvfile)
-	if err != nil {
-		return err
-	}
-	csvwriter := csv.NewWriter(inputWriter)
-	for _, entry := range entries {
-		if err = csvwriter.Write(entry.ToCSV()); err != nil {
+// writeCSV
+func writeCSV(target string, entries []DatasetEntry) error {
+	return util.WriteFileAtomic(target, func(csvfile io.Writer) error {
+		// write lines - Convert and store as UTF-8
+		inputWriter, err := charset.NewWriter("utf-8", csvfile)
+		if err != nil {
 			return err
 		}
-	}
-
-	csvwriter.Flush()
-
-	if err = csvfile.Close(); err != nil {
-		return err
-	}
+		csvwriter := csv.NewWriter(inputWriter)
+		for _, entry := range entries {
+			if err = csvwriter.Write(entry.ToCSV()); err != nil {
+				return err
+			}
+		}
 
-	return nil
+		csvwriter.Flush()
+		return csvwriter.Error()
+	})
 }
 
 // readCSV
@@ -444,9 +428,64 @@ func readCSV(source string) ([]DatasetEntry, error) {
 	for i, line := range lines {
 		entry, errRead := converter.FromCSV(line)
 		if errRead != nil {
-			fmt.Println(fmt.Sprintf("Warn: error parsing CSV line %v: %v", i, errRead.Error()))
+			return nil, fmt.Errorf("error parsing CSV line %v: %v", i+1, errRead)
 		}
 		entries = readInDatasetEntry(entries, entry)
 	}
 	return entries, nil
 }
+
+// loadDataset reads the entries of a local file or fetches them from a remote dataset.
+// Also returns a label describing where the data came from.
+func loadDataset(ctx context.Context, input string) (entries []DatasetEntry, label string, err error) {
+	if !isRemoteDataset(input) {
+		if entries, err = readDataset(input); err != nil {
+			return nil, "", err
+		}
+		return entries, fmt.Sprintf("local file '%v'", input), nil
+	}
+
+	datasetInfo := query.AITrainerGroup{}
+	if datasetInfo, entries, err = fetchRemoteDataset(ctx, parseDatasetID(input)); err != nil {
+		return nil, "", err
+	}
+	return entries, fmt.Sprintf("remote dataset '%v' (%v)", datasetInfo.Name, datasetInfo.ID), nil
+}
+
+// parseDatasetID extracts the dataset ID from a full Kajiwoto dataset URL (e.g. https://kajiwoto.com/d/XXX).
+// Any other input is considered to be a plain dataset ID and returned unchanged.
+func parseDatasetID(input string) string {
+	if id, ok := datasetIDFromURL(input); ok {
+		return id
+	}
+	return input
+}
+
+// datasetIDFromURL returns the dataset ID if input is a valid Kajiwoto dataset URL
+func datasetIDFromURL(input string) (string, bool) {
+	parsed, err := url.Parse(input)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return "", false
+	}
+	host := strings.TrimPrefix(parsed.Hostname(), "www.")
+	if host != datasetURLHost || !strings.HasPrefix(parsed.Path, datasetURLPathPrefix) {
+		return "", false
+	}
+	id := strings.Trim(strings.TrimPrefix(parsed.Path, datasetURLPathPrefix), "/")
+	if id == "" || strings.Contains(id, "/") {
+		return "", false
+	}
+	return id, true
+}
+
+// isRemoteDataset tells whether input refers to a remote dataset rather than a local file.
+// Dataset URLs are always remote; existing files and paths with a file extension are always local.
+func isRemoteDataset(input string) bool {
+	if _, ok := datasetIDFromURL(input); ok {
+		return true
+	}
+	if _, err := os.Stat(input); err == nil {
+		return false
+	}
+	return filepath.Ext(input) == ""
+}
@@ -1 +1,240 @@
 package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/runtimeracer/go-graphql-client"
+	"github.com/runtimeracer/kajitool/query"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+const (
+	testUserID   = "user-1"
+	testUsername = "tester"
+	testPassword = "secret"
+	// testPassphrase encrypts the credentials file of the test environment
+	testPassphrase = "passphrase"
+)
+
+// testEnvironment holds a fake API and a temporary directory for a single test
+type testEnvironment struct {
+	t          *testing.T
+	client     *query.FakeKajiwotoClient
+	sessionKey string
+	dir        string
+	configFile string
+}
+
+// newTestEnvironment replaces the API client of all commands with a fake containing a single user
+func newTestEnvironment(t *testing.T) *testEnvironment {
+	env := &testEnvironment{
+		t:      t,
+		client: query.NewFakeKajiwotoClient(),
+		dir:    t.TempDir(),
+	}
+	env.sessionKey = env.client.AddUser(query.User{
+		ID:          testUserID,
+		Username:    testUsername,
+		DisplayName: "Tester",
+	}, testPassword)
+
+	env.configFile = filepath.Join(env.dir, "kajitool.yaml")
+	if err := ioutil.WriteFile(env.configFile, []byte{}, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Settings of previous tests (e.g. the credentials reference written on login) must not leak
+	viper.Reset()
+
+	previous := newKajiwotoClient
+	newKajiwotoClient = func(endpoint string) (query.KajiwotoClient, error) {
+		return env.client, nil
+	}
+	previousPassphrase := readPassphrase
+	readPassphrase = func() ([]byte, error) {
+		return []byte(testPassphrase), nil
+	}
+	previousPassword := readPassword
+	readPassword = func(username string) ([]byte, error) {
+		return []byte(testPassword), nil
+	}
+	t.Cleanup(func() {
+		newKajiwotoClient = previous
+		readPassphrase = previousPassphrase
+		readPassword = previousPassword
+	})
+	return env
+}
+
+// path returns the path of a file inside the temporary directory
+func (env *testEnvironment) path(name string) string {
+	return filepath.Join(env.dir, name)
+}
+
+// execute runs kajitool with the specified arguments, using the config file, credentials file and session key of the environment
+func (env *testEnvironment) execute(args ...string) error {
+	resetFlags(rootCmd)
+	global := []string{"--config", env.configFile, "--credentials-file", env.path("credentials")}
+	if env.sessionKey != "" {
+		global = append(global, "--sessionkey", env.sessionKey)
+	}
+	rootCmd.SetArgs(append(global, args...))
+	return rootCmd.Execute()
+}
+
+// executeWithOutput runs the command like execute and returns everything it wrote to stdout
+func (env *testEnvironment) executeWithOutput(args ...string) (string, error) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		env.t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = writer
+	output := make(chan []byte)
+	go func() {
+		content, _ := ioutil.ReadAll(reader)
+		output <- content
+	}()
+
+	err = env.execute(args...)
+	os.Stdout = stdout
+	_ = writer.Close()
+	return string(<-output), err
+}
+
+// addDataset adds a dataset owned by ownerID containing the specified entries
+func (env *testEnvironment) addDataset(id, ownerID string, price int, entries ...DatasetEntry) {
+	trained := make([]query.AITrained, len(entries))
+	for i, entry := range entries {
+		history := make([]graphql.String, len(entry.History))
+		for j, item := range entry.History {
+			history[j] = graphql.String(item)
+		}
+		trained[i] = query.AITrained{
+			ID:          graphql.String(entry.ID),
+			UserMessage: graphql.String(entry.UserMessage),
+			Message:     graphql.String(entry.Message),
+			ASM:         graphql.String(entry.ASM),
+			Condition:   graphql.String(entry.Condition.String()),
+			History:     history,
+		}
+	}
+	env.client.AddDataset(query.AITrainerGroup{
+		ID:    graphql.String(id),
+		Name:  graphql.String("Dataset " + id),
+		Price: graphql.Int(price),
+		User:  query.User{ID: graphql.String(ownerID)},
+	}, trained)
+}
+
+// resetFlags restores the default values of all flags, since cobra keeps them between executions
+func resetFlags(cmd *cobra.Command) {
+	reset := func(f *pflag.Flag) {
+		// Setting slice values appends to them once set
+		if slice, ok := f.Value.(pflag.SliceValue); ok {
+			_ = slice.Replace(nil)
+		} else {
+			_ = f.Value.Set(f.DefValue)
+		}
+		f.Changed = false
+	}
+	cmd.Flags().VisitAll(reset)
+	cmd.PersistentFlags().VisitAll(reset)
+	for _, child := range cmd.Commands() {
+		resetFlags(child)
+	}
+}
+
+// newTestEntry creates a dataset entry without emotion and conditions
+func newTestEntry(id, userMessage, message string, history ...string) DatasetEntry {
+	return DatasetEntry{
+		ID:          id,
+		UserMessage: userMessage,
+		Message:     message,
+		ASM:         "none",
+		Condition:   Condition{Attachment: AttachmentAny},
+		History:     history,
+	}
+}
+
+func TestDatasetEntryCSVRoundTrip(t *testing.T) {
+	entry := DatasetEntry{
+		ID:           "abc",
+		UserMessage:  "How are you?",
+		Message:      "I'm fine",
+		ASM:          "HAPPY",
+		Condition:    Condition{Daytime: DaytimeEarlyMorningTillMorning, LastSeen: LastSeen2HoursAgo, Attachment: AttachmentLiked},
+		Deleted:      true,
+		History:      []string{"Hello", "Hi"},
+		DuplicateIDs: []string{"def"},
+	}
+
+	converter := &DatasetEntry{}
+	result, err := converter.FromCSV(entry.ToCSV())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(entry, result); diff != "" {
+		t.Errorf("unexpected entry after round trip (-want +got):\n%v", diff)
+	}
+}
+
+func TestParseDatasetID(t *testing.T) {
+	tests := map[string]string{
+		"abc123":                         "abc123",
+		"https://kajiwoto.com/d/abc123":  "abc123",
+		"https://kajiwoto.com/d/abc123/": "abc123",
+		"https://www.kajiwoto.com/d/xyz": "xyz",
+		"https://example.com/d/abc123":   "https://example.com/d/abc123",
+		"https://kajiwoto.com/u/someone": "https://kajiwoto.com/u/someone",
+	}
+	for input, want := range tests {
+		if got := parseDatasetID(input); got != want {
+			t.Errorf("parseDatasetID(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestIsDuplicateTreatsEmptyHistoryAsEqual(t *testing.T) {
+	local := newTestEntry("", "Hello", "Hi")
+	remote := newTestEntry("abc", "Hello", "Hi")
+	remote.History = make([]string, 0)
+
+	if !local.isDuplicate(&remote) {
+		t.Error("entries with nil and empty history should be duplicates")
+	}
+}
+
+func TestWriteDatasetReplacesAtomically(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "dataset.csv")
+	if err := writeDataset(target, []DatasetEntry{newTestEntry("1", "Hello", "Hi")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeDataset(target, []DatasetEntry{newTestEntry("2", "Bye", "See you")}); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := readDataset(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].ID != "2" {
+		t.Errorf("expected file to be replaced, got %v", entries)
+	}
+
+	// No temporary files must be left behind
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("expected only the target file, found %v files", len(files))
+	}
+}
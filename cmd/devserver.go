@@ -0,0 +1,92 @@
+// Package cmd
+/*
+Copyright © 2021 NAME HERE runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/runtimeracer/go-graphql-client"
+	"github.com/runtimeracer/kajitool/internal/fakeapi"
+	"github.com/runtimeracer/kajitool/query"
+	"github.com/spf13/cobra"
+)
+
+// Flags
+var devserverListen, devserverStore, devserverUser, devserverPassword, devserverDataset string
+
+// devserverCmd represents the devserver command
+var devserverCmd = &cobra.Command{
+	Use:   "devserver",
+	Short: "Runs a local stand-in for the Kajiwoto API",
+	Long: `devserver runs a local GraphQL server emulating the parts of the Kajiwoto API used by kajitool.
+Point the --endpoint flag of other commands at it to rehearse uploads and syncs offline.
+
+All data is kept in a JSON store file. If the store doesn't exist yet, it is created containing a single user
+and an empty dataset owned by this user.
+
+Example:
+  kajitool devserver
+  KAJI_PASSWORD=dev kajitool --endpoint http://127.0.0.1:8642/graphql login -u dev
+  kajitool --endpoint http://127.0.0.1:8642/graphql dataset upload -s dataset.csv -t dev`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, exists, err := fakeapi.LoadStore(devserverStore)
+		if err != nil {
+			return err
+		}
+
+		// Seed new store
+		if !exists {
+			user := query.User{
+				ID:          graphql.String(fmt.Sprintf("%v-user", devserverUser)),
+				Username:    graphql.String(devserverUser),
+				DisplayName: graphql.String(devserverUser),
+				Activated:   true,
+			}
+			client.AddUser(user, devserverPassword)
+			client.AddDataset(query.AITrainerGroup{
+				ID:     graphql.String(devserverDataset),
+				Name:   graphql.String(devserverDataset),
+				Status: "ACTIVE",
+				User:   user,
+			}, nil)
+			if err = fakeapi.SaveStore(devserverStore, client); err != nil {
+				return err
+			}
+			fmt.Println(fmt.Sprintf("Created store %v with user '%v' and dataset '%v'", devserverStore, devserverUser, devserverDataset))
+		} else {
+			fmt.Println(fmt.Sprintf("Using store %v", devserverStore))
+		}
+
+		mux := http.NewServeMux()
+		mux.Handle("/graphql", fakeapi.NewServer(client, devserverStore))
+
+		fmt.Println(fmt.Sprintf("Serving fake Kajiwoto API at http://%v/graphql", devserverListen))
+		return http.ListenAndServe(devserverListen, mux)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(devserverCmd)
+
+	// Flags for devserver
+	devserverCmd.Flags().StringVar(&devserverListen, "listen", "127.0.0.1:8642", "address to listen on")
+	devserverCmd.Flags().StringVar(&devserverStore, "store", "kajitool-devserver.json", "JSON file holding users, sessions and datasets")
+	devserverCmd.Flags().StringVar(&devserverUser, "user", "dev", "username of the user created for a new store")
+	devserverCmd.Flags().StringVar(&devserverPassword, "password", "dev", "password of the user created for a new store")
+	devserverCmd.Flags().StringVar(&devserverDataset, "dataset", "dev", "ID of the dataset created for a new store")
+}
@@ -17,36 +17,273 @@ limitations under the License.
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/runtimeracer/kajitool/constants"
 	"github.com/spf13/cobra"
 )
 
 // diffCmd represents the diff command
 var diffCmd = &cobra.Command{
 	Use:   "diff",
-	Short: "A brief description of your command",
-	Long: `A longer description that spans multiple lines and likely contains examples
-and usage of using your command. For example:
-
-Cobra is a CLI library for Go that empowers applications.
-This application is a tool to generate the needed files
-to quickly create a Cobra application.`,
-	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("diff called")
+	Short: "Points out the differences between a source and a target dataset.",
+	Long: `diff reads the specified source and target datasets and reports which entries have been added, removed or modified.
+Each side can either be a local file or a remote dataset, which will be fetched from the API in memory.
+
+Entries are matched by their ID first. Entries without a matching ID are matched by their content afterwards.
+For modified entries, every changed field (UserMessage, Message, ASM, Conditions, History) is listed.
+
+param source: a local file (csv, json, jsonl or sqlite), a full Kajiwoto dataset URL (including ID), or a Kajiwoto dataset ID.
+param target: a local file (csv, json, jsonl or sqlite), a full Kajiwoto dataset URL (including ID), or a Kajiwoto dataset ID.`,
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+
+		// Sanity checks
+		if source, err = validateDiffSource(source); err != nil {
+			return err
+		}
+		if target, err = validateDiffTarget(target); err != nil {
+			return err
+		}
+
+		// Read data from both sides
+		var sourceData, targetData []DatasetEntry
+		var sourceLabel, targetLabel string
+		if sourceData, sourceLabel, err = loadDataset(cmd.Context(), source); err != nil {
+			return err
+		}
+		if targetData, targetLabel, err = loadDataset(cmd.Context(), target); err != nil {
+			return err
+		}
+
+		fmt.Println(fmt.Sprintf("Source: %v (%v entries)", sourceLabel, len(sourceData)))
+		fmt.Println(fmt.Sprintf("Target: %v (%v entries)", targetLabel, len(targetData)))
+
+		// Compare and print the result
+		result := diffDatasetEntries(sourceData, targetData)
+		printDatasetDiff(result, sourceLabel, targetLabel)
+
+		return nil
 	},
 }
 
 func init() {
 	datasetCmd.AddCommand(diffCmd)
+}
+
+func validateDiffSource(source string) (string, error) {
+	if source == "" {
+		return "", errors.New("empty source")
+	}
+
+	return source, nil
+}
+
+func validateDiffTarget(target string) (string, error) {
+	if target == "" {
+		return "", errors.New("empty target")
+	}
+
+	return target, nil
+}
+
+// datasetDiff holds the result of comparing a source with a target dataset
+type datasetDiff struct {
+	// Added contains entries only existing in the target
+	Added []DatasetEntry
+	// Removed contains entries only existing in the source
+	Removed []DatasetEntry
+	// Modified contains entries existing in both, but with different content
+	Modified []datasetEntryChange
+	// Unchanged is the amount of entries which are identical in source and target
+	Unchanged int
+}
+
+// hasChanges tells whether source and target differ at all
+func (d *datasetDiff) hasChanges() bool {
+	return len(d.Added) > 0 || len(d.Removed) > 0 || len(d.Modified) > 0
+}
+
+// datasetEntryChange describes the changes between two entries sharing the same ID
+type datasetEntryChange struct {
+	Source DatasetEntry
+	Target DatasetEntry
+	Fields []datasetFieldChange
+}
 
-	// Here you will define your flags and configuration settings.
+// datasetFieldChange describes a single changed field of a dataset entry
+type datasetFieldChange struct {
+	Field  string
+	Source string
+	Target string
+}
+
+// diffDatasetEntries compares source and target entries.
+// Entries are matched by ID first; the remaining ones are matched by content, using the same rules as isDuplicate.
+func diffDatasetEntries(sourceData, targetData []DatasetEntry) datasetDiff {
+	result := datasetDiff{
+		Added:    make([]DatasetEntry, 0),
+		Removed:  make([]DatasetEntry, 0),
+		Modified: make([]datasetEntryChange, 0),
+	}
+
+	// Index target entries by ID
+	targetIDMap := make(map[string][]int)
+	for i, entry := range targetData {
+		if entry.ID != "" {
+			targetIDMap[entry.ID] = append(targetIDMap[entry.ID], i)
+		}
+	}
+	sourceMatched := make([]bool, len(sourceData))
+	targetMatched := make([]bool, len(targetData))
+
+	// 1. Match by ID
+	for i := range sourceData {
+		sourceEntry := &sourceData[i]
+		if sourceEntry.ID == "" {
+			continue
+		}
+		for _, j := range targetIDMap[sourceEntry.ID] {
+			if targetMatched[j] {
+				continue
+			}
+			sourceMatched[i] = true
+			targetMatched[j] = true
+
+			if fields := compareDatasetEntries(sourceEntry, &targetData[j]); len(fields) > 0 {
+				result.Modified = append(result.Modified, datasetEntryChange{
+					Source: *sourceEntry,
+					Target: targetData[j],
+					Fields: fields,
+				})
+			} else {
+				result.Unchanged++
+			}
+			break
+		}
+	}
+
+	// 2. Match remaining entries by content
+	for i := range sourceData {
+		if sourceMatched[i] {
+			continue
+		}
+		sourceEntry := &sourceData[i]
+		for j := range targetData {
+			if targetMatched[j] {
+				continue
+			}
+			if sourceEntry.isDuplicate(&targetData[j]) {
+				sourceMatched[i] = true
+				targetMatched[j] = true
+				result.Unchanged++
+				break
+			}
+		}
+	}
+
+	// 3. Everything left is either removed or added
+	for i, entry := range sourceData {
+		if !sourceMatched[i] {
+			result.Removed = append(result.Removed, entry)
+		}
+	}
+	for j, entry := range targetData {
+		if !targetMatched[j] {
+			result.Added = append(result.Added, entry)
+		}
+	}
+
+	return result
+}
+
+// compareDatasetEntries returns a list of all fields which differ between source and target
+func compareDatasetEntries(sourceEntry, targetEntry *DatasetEntry) []datasetFieldChange {
+	fields := make([]datasetFieldChange, 0)
+	addIfChanged := func(field, sourceValue, targetValue string) {
+		if sourceValue != targetValue {
+			fields = append(fields, datasetFieldChange{
+				Field:  field,
+				Source: sourceValue,
+				Target: targetValue,
+			})
+		}
+	}
 
-	// Cobra supports Persistent Flags which will work for this command
-	// and all subcommands, e.g.:
-	// diffCmd.PersistentFlags().String("foo", "", "A help for foo")
+	addIfChanged("UserMessage", sourceEntry.UserMessage, targetEntry.UserMessage)
+	addIfChanged("Message", sourceEntry.Message, targetEntry.Message)
+	// Compare the emotion keys, since unknown ones have no readable name
+	if sourceEntry.ASM != targetEntry.ASM {
+		fields = append(fields, datasetFieldChange{
+			Field:  "ASM",
+			Source: asmDisplayName(sourceEntry.ASM),
+			Target: asmDisplayName(targetEntry.ASM),
+		})
+	}
+
+	// Condition components
+	addIfChanged("Daytime", sourceEntry.Condition.Daytime.Name(), targetEntry.Condition.Daytime.Name())
+	addIfChanged("LastSeen", sourceEntry.Condition.LastSeen.Name(), targetEntry.Condition.LastSeen.Name())
+	addIfChanged("Attachment", sourceEntry.Condition.Attachment.Name(), targetEntry.Condition.Attachment.Name())
+
+	if !cmp.Equal(sourceEntry.History, targetEntry.History, cmpopts.EquateEmpty()) {
+		addIfChanged("History",
+			strings.Join(sourceEntry.History, constants.CSVListSeparator),
+			strings.Join(targetEntry.History, constants.CSVListSeparator))
+	}
+
+	return fields
+}
+
+// asmDisplayName returns the readable name of an emotion key, or the key itself if unknown
+func asmDisplayName(asm string) string {
+	if name, ok := asmMap[asm]; ok {
+		return name
+	}
+	return asm
+}
+
+// printDatasetDiff prints a human readable report of a dataset diff
+func printDatasetDiff(result datasetDiff, sourceLabel, targetLabel string) {
+	if !result.hasChanges() {
+		fmt.Println(fmt.Sprintf("No differences found. %v identical entries.", result.Unchanged))
+		return
+	}
+
+	if len(result.Added) > 0 {
+		fmt.Println(fmt.Sprintf("\nAdded entries, only in %v (%v):", targetLabel, len(result.Added)))
+		for _, entry := range result.Added {
+			fmt.Println(fmt.Sprintf("+ %v", describeDatasetEntry(entry)))
+		}
+	}
+	if len(result.Removed) > 0 {
+		fmt.Println(fmt.Sprintf("\nRemoved entries, only in %v (%v):", sourceLabel, len(result.Removed)))
+		for _, entry := range result.Removed {
+			fmt.Println(fmt.Sprintf("- %v", describeDatasetEntry(entry)))
+		}
+	}
+	if len(result.Modified) > 0 {
+		fmt.Println(fmt.Sprintf("\nModified entries, different in both (%v):", len(result.Modified)))
+		for _, change := range result.Modified {
+			fmt.Println(fmt.Sprintf("~ %v", describeDatasetEntry(change.Source)))
+			for _, field := range change.Fields {
+				fmt.Println(fmt.Sprintf("    %v: '%v' => '%v'", field.Field, field.Source, field.Target))
+			}
+		}
+	}
+
+	fmt.Println(fmt.Sprintf("\nSummary: %v added, %v removed, %v modified, %v unchanged.",
+		len(result.Added), len(result.Removed), len(result.Modified), result.Unchanged))
+}
 
-	// Cobra supports local flags which will only run when this command
-	// is called directly, e.g.:
-	// diffCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+// describeDatasetEntry creates a short single line description of a dataset entry
+func describeDatasetEntry(entry DatasetEntry) string {
+	id := entry.ID
+	if id == "" {
+		id = "NO ID"
+	}
+	return fmt.Sprintf("[%v] U: '%v' K: '%v'", id, entry.UserMessage, entry.Message)
 }
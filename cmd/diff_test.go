@@ -0,0 +1,238 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDiffDatasetEntries(t *testing.T) {
+	changed := newTestEntry("a", "Hello", "Hey there")
+	changed.ASM = "HAPPY"
+	changed.Condition.Daytime = DaytimeMorning
+	changed.History = []string{"Hi"}
+
+	tests := []struct {
+		name      string
+		source    []DatasetEntry
+		target    []DatasetEntry
+		added     []string
+		removed   []string
+		modified  []string
+		fields    []string
+		unchanged int
+	}{
+		{
+			name:      "identical",
+			source:    []DatasetEntry{newTestEntry("a", "Hello", "Hi")},
+			target:    []DatasetEntry{newTestEntry("a", "Hello", "Hi")},
+			unchanged: 1,
+		},
+		{
+			name:     "same ID with changed content",
+			source:   []DatasetEntry{newTestEntry("a", "Hello", "Hi")},
+			target:   []DatasetEntry{changed},
+			modified: []string{"a"},
+			fields:   []string{"Message", "ASM", "Daytime", "History"},
+		},
+		{
+			name:      "matching content with different ID",
+			source:    []DatasetEntry{newTestEntry("a", "Hello", "Hi")},
+			target:    []DatasetEntry{newTestEntry("b", "Hello", "Hi")},
+			unchanged: 1,
+		},
+		{
+			name:      "matching content without ID",
+			source:    []DatasetEntry{newTestEntry("", "Hello", "Hi")},
+			target:    []DatasetEntry{newTestEntry("a", "Hello", "Hi")},
+			unchanged: 1,
+		},
+		{
+			name:    "different content with different ID",
+			source:  []DatasetEntry{newTestEntry("a", "Hello", "Hi")},
+			target:  []DatasetEntry{newTestEntry("b", "Bye", "See you")},
+			added:   []string{"b"},
+			removed: []string{"a"},
+		},
+		{
+			name: "duplicates in source",
+			source: []DatasetEntry{
+				newTestEntry("a", "Hello", "Hi"),
+				newTestEntry("b", "Hello", "Hi"),
+			},
+			target:    []DatasetEntry{newTestEntry("a", "Hello", "Hi")},
+			removed:   []string{"b"},
+			unchanged: 1,
+		},
+		{
+			name:   "duplicates in target",
+			source: []DatasetEntry{newTestEntry("", "Hello", "Hi")},
+			target: []DatasetEntry{
+				newTestEntry("a", "Hello", "Hi"),
+				newTestEntry("b", "Hello", "Hi"),
+			},
+			added:     []string{"b"},
+			unchanged: 1,
+		},
+		{
+			name:   "duplicate ID",
+			source: []DatasetEntry{newTestEntry("a", "Hello", "Hi")},
+			target: []DatasetEntry{
+				newTestEntry("a", "Hello", "Hi"),
+				newTestEntry("a", "Bye", "See you"),
+			},
+			added:     []string{"a"},
+			unchanged: 1,
+		},
+		{
+			name:      "only in source",
+			source:    []DatasetEntry{newTestEntry("a", "Hello", "Hi"), newTestEntry("b", "Bye", "See you")},
+			target:    []DatasetEntry{newTestEntry("a", "Hello", "Hi")},
+			removed:   []string{"b"},
+			unchanged: 1,
+		},
+		{
+			name:   "only in target",
+			source: []DatasetEntry{},
+			target: []DatasetEntry{newTestEntry("a", "Hello", "Hi")},
+			added:  []string{"a"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := diffDatasetEntries(test.source, test.target)
+			if got := entryIDs(result.Added); !equalStrings(got, test.added) {
+				t.Errorf("added: got %v, want %v", got, test.added)
+			}
+			if got := entryIDs(result.Removed); !equalStrings(got, test.removed) {
+				t.Errorf("removed: got %v, want %v", got, test.removed)
+			}
+			modified := make([]string, 0)
+			fields := make([]string, 0)
+			for _, change := range result.Modified {
+				modified = append(modified, change.Source.ID)
+				for _, field := range change.Fields {
+					fields = append(fields, field.Field)
+				}
+			}
+			if !equalStrings(modified, test.modified) {
+				t.Errorf("modified: got %v, want %v", modified, test.modified)
+			}
+			if !equalStrings(fields, test.fields) {
+				t.Errorf("changed fields: got %v, want %v", fields, test.fields)
+			}
+			if result.Unchanged != test.unchanged {
+				t.Errorf("unchanged: got %v, want %v", result.Unchanged, test.unchanged)
+			}
+			if result.hasChanges() != (len(test.added)+len(test.removed)+len(test.modified) > 0) {
+				t.Errorf("unexpected hasChanges() = %v", result.hasChanges())
+			}
+		})
+	}
+}
+
+func TestCompareDatasetEntriesTreatsEmptyHistoryAsEqual(t *testing.T) {
+	source := newTestEntry("a", "Hello", "Hi")
+	target := newTestEntry("a", "Hello", "Hi")
+	target.History = make([]string, 0)
+
+	if fields := compareDatasetEntries(&source, &target); len(fields) > 0 {
+		t.Errorf("expected no changes, got %v", fields)
+	}
+}
+
+func TestCompareDatasetEntriesReportsUnknownASM(t *testing.T) {
+	source := newTestEntry("a", "Hello", "Hi")
+	source.ASM = "UNKNOWN_1"
+	target := newTestEntry("a", "Hello", "Hi")
+	target.ASM = "UNKNOWN_2"
+
+	fields := compareDatasetEntries(&source, &target)
+	if len(fields) != 1 || fields[0].Field != "ASM" || fields[0].Source != "UNKNOWN_1" || fields[0].Target != "UNKNOWN_2" {
+		t.Errorf("expected changed unknown emotion to be reported, got %v", fields)
+	}
+}
+
+// entryIDs returns the IDs of all entries
+func entryIDs(entries []DatasetEntry) []string {
+	ids := make([]string, len(entries))
+	for i, entry := range entries {
+		ids[i] = entry.ID
+	}
+	return ids
+}
+
+// equalStrings compares two lists, treating nil and empty ones as equal
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestDiffAgainstRemoteDataset(t *testing.T) {
+	env := newTestEnvironment(t)
+	env.addDataset("ds1", testUserID, 0,
+		newTestEntry("a", "Hello", "Hi"),
+		newTestEntry("b", "How are you?", "Fine"),
+		newTestEntry("c", "Bye", "See you"),
+	)
+
+	source := env.path("dataset.csv")
+	if err := writeDataset(source, []DatasetEntry{
+		newTestEntry("a", "Hello", "Hi"),
+		newTestEntry("b", "How are you?", "Great"),
+		newTestEntry("", "Really?", "Yes"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := env.execute("dataset", "diff", "-s", source, "-t", "https://kajiwoto.com/d/ds1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The remote side is fetched through the same path as the command
+	local, _, err := loadDataset(context.Background(), source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, label, err := loadDataset(context.Background(), "ds1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if label != "remote dataset 'Dataset ds1' (ds1)" {
+		t.Errorf("unexpected label %q", label)
+	}
+
+	result := diffDatasetEntries(local, remote)
+	if got := entryIDs(result.Added); !equalStrings(got, []string{"c"}) {
+		t.Errorf("added: got %v, want [c]", got)
+	}
+	if len(result.Removed) != 1 || result.Removed[0].UserMessage != "Really?" {
+		t.Errorf("removed: got %v, want the local only entry", result.Removed)
+	}
+	if len(result.Modified) != 1 || result.Modified[0].Source.ID != "b" {
+		t.Errorf("modified: got %v, want [b]", result.Modified)
+	}
+	if result.Unchanged != 1 {
+		t.Errorf("unchanged: got %v, want 1", result.Unchanged)
+	}
+}
+
+func TestDiffAgainstMissingRemoteDataset(t *testing.T) {
+	env := newTestEnvironment(t)
+
+	source := env.path("dataset.csv")
+	if err := writeDataset(source, []DatasetEntry{newTestEntry("a", "Hello", "Hi")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := env.execute("dataset", "diff", "-s", source, "-t", "missing"); err == nil {
+		t.Fatal("expected diff against a missing remote dataset to fail")
+	}
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/runtimeracer/kajitool/constants"
@@ -24,8 +25,6 @@ import (
 	"github.com/runtimeracer/kajitool/util"
 	"github.com/spf13/cobra"
 	"sort"
-	"strings"
-	"time"
 )
 
 // downloadCmd represents the download command
@@ -35,7 +34,9 @@ var downloadCmd = &cobra.Command{
 	Long: `download fetches dataset content from the specified source dataset and saves it into the specified target file. 
 
 param source: a full Kajiwoto dataset URL (including ID), or a Kajiwoto dataset ID.
-param target: must be a local file. Data will be saved in csv format.`,
+param target: must be a local file. Data will be saved in csv, json, jsonl or sqlite format, depending on file extension or --format.
+
+Using --meta, all metadata of the dataset including its documents is written into '<target>.meta.json'.`,
 	RunE: func(cmd *cobra.Command, args []string) (err error) {
 
 		// Sanity checks
@@ -46,85 +47,126 @@ param target: must be a local file. Data will be saved in csv format.`,
 			return err
 		}
 
-		// Init Client
-		client := query.GetKajiwotoClient(endpoint)
-
-		// Login via Session key
-		loginResult := query.LoginResult{}
-		if loginResult, err = client.DoLoginAuthToken(sessionKey); err != nil {
+		// Fetch the whole dataset from the API
+		var datasetInfo query.AITrainerGroup
+		var datasetContent []DatasetEntry
+		if datasetInfo, datasetContent, err = fetchRemoteDataset(cmd.Context(), source); err != nil {
 			return err
 		}
 
-		// Get User Info from Login result
-		userInfo := &loginResult.Login.User
+		// Organize Dataset entries to place related ones next to each other
+		datasetContent = orderDatasetEntries(datasetContent)
 
-		// Get Info on the source Dataset
-		datasetInfo := query.AITrainerGroup{}
-		if datasetInfo, err = client.GetAITrainerGroup(source, sessionKey); err != nil {
+		// Write to target file
+		if err = writeDatasetWithInfo(target, datasetInfo, datasetContent); err != nil {
 			return err
 		}
 
-		// Print some info on the Dataset
-		fmt.Println(fmt.Sprintf("Dataset found: %v", datasetInfo.Name))
-		fmt.Println(fmt.Sprintf("Indexed entries: %v", datasetInfo.Count))
-
-		/*
-			Safety mechanism: Only allow download of own datasets
-			This is due to the some creators on kajiwoto selling complex datasets for coins and earning money from it.
-			Being able to download the contents of a dataset and re-uploading it to an own dataset via kajitool, would
-			make it very easy to bypass this. Of course the code switch is easily removed, but I want you to be aware
-			of what you're doing here.
-
-			Please don't be cheap. If you like a dataset, please respect the work put into it by the creator, and BUY IT!
-		*/
-		if datasetInfo.User.ID != userInfo.ID && datasetInfo.Price > 0 && !datasetInfo.Purchased {
-			return errors.New("not your dataset! Please buy it to be able to download")
+		// Write dataset metadata and documents into the companion file
+		if writeMeta {
+			if err = writeDatasetMeta(target, datasetInfo); err != nil {
+				return err
+			}
+			fmt.Println(fmt.Sprintf("Metadata written to: %v", datasetMetaPath(target)))
 		}
 
-		datasetContent := make([]DatasetEntry, 0)
+		return nil
+	},
+}
 
-		// Fetch Dataset into result list
-		// Continue as long as the result set size equals fetch limit, which means there must be another page
-		var page = 0
-		var datasetQueryResult []query.AITrained
-		for limit := constants.FetchLimit; limit >= constants.FetchLimit; page++ {
-			// Read subset of dataset
-			datasetQueryResult, err = client.GetAITrainedList(string(datasetInfo.ID), "", sessionKey, limit, page)
-			if err != nil {
-				return err
-			}
+// fetchRemoteDataset logs in via session key and fetches info and all entries of the specified dataset.
+func fetchRemoteDataset(ctx context.Context, datasetID string) (datasetInfo query.AITrainerGroup, datasetContent []DatasetEntry, err error) {
+	// Load session key from the credential store
+	if err = loadSessionKey(); err != nil {
+		return datasetInfo, datasetContent, err
+	}
 
-			// Update limit to determine if we do another fetch
-			limit = len(datasetQueryResult)
+	// Init Client
+	client, err := newSessionClient(endpoint)
+	if err != nil {
+		return datasetInfo, datasetContent, err
+	}
 
-			// Convert GraphQL Results into internal format
-			converter := &DatasetEntry{}
-			for _, data := range datasetQueryResult {
-				entry := converter.FromAITrained(data)
-				datasetContent = readInDatasetEntry(datasetContent, entry)
-			}
+	// Login via Session key
+	loginResult := query.LoginResult{}
+	if loginResult, err = client.DoLoginAuthToken(ctx, sessionKey); err != nil {
+		return datasetInfo, datasetContent, err
+	}
 
-			if limit >= constants.FetchLimit {
-				// Print intermediate amount of fetched entries
-				fmt.Println(fmt.Sprintf("fetched %v dataset entries...", len(datasetContent)))
-				// Sleep 2 secs to not bombard the API
-				time.Sleep(time.Second * 2)
-			}
-		}
+	// Get User Info from Login result
+	userInfo := &loginResult.Login.User
+
+	// Get Info on the source Dataset
+	if datasetInfo, err = client.GetAITrainerGroup(ctx, datasetID, sessionKey); err != nil {
+		return datasetInfo, datasetContent, err
+	}
 
-		// Inform user on amount of fetch
-		fmt.Println(fmt.Sprintf("Done. Fetched %v dataset entries.", len(datasetContent)))
+	// Print some info on the Dataset
+	fmt.Println(fmt.Sprintf("Dataset found: %v", datasetInfo.Name))
+	fmt.Println(fmt.Sprintf("Indexed entries: %v", datasetInfo.Count))
+
+	/*
+		Safety mechanism: Only allow download of own datasets
+		This is due to the some creators on kajiwoto selling complex datasets for coins and earning money from it.
+		Being able to download the contents of a dataset and re-uploading it to an own dataset via kajitool, would
+		make it very easy to bypass this. Of course the code switch is easily removed, but I want you to be aware
+		of what you're doing here.
+
+		Please don't be cheap. If you like a dataset, please respect the work put into it by the creator, and BUY IT!
+	*/
+	if datasetInfo.User.ID != userInfo.ID && datasetInfo.Price > 0 && !datasetInfo.Purchased {
+		return datasetInfo, datasetContent, errors.New("not your dataset! Please buy it to be able to download")
+	}
 
-		// Organize Dataset entries to place related ones next to each other
-		datasetContent = orderDatasetEntries(datasetContent)
+	// Fetch Dataset into result list
+	if datasetContent, _, err = fetchDatasetEntries(ctx, client, string(datasetInfo.ID)); err != nil {
+		return datasetInfo, datasetContent, err
+	}
 
-		// Write to target file
-		if err = writeCSV(target, datasetContent); err != nil {
-			return err
+	// Inform user on amount of fetch
+	fmt.Println(fmt.Sprintf("Done. Fetched %v dataset entries.", len(datasetContent)))
+
+	return datasetInfo, datasetContent, nil
+}
+
+// fetchDatasetEntries fetches all entries of the specified dataset.
+// Entries which can't be converted are reported and skipped; their IDs are returned as invalidIDs.
+// Continues as long as the result set size equals fetch limit, which means there must be another page.
+func fetchDatasetEntries(ctx context.Context, client query.KajiwotoClient, datasetID string) (datasetContent []DatasetEntry, invalidIDs []string, err error) {
+	datasetContent = make([]DatasetEntry, 0)
+	invalidIDs = make([]string, 0)
+
+	var page = 0
+	var datasetQueryResult []query.AITrained
+	for limit := constants.FetchLimit; limit >= constants.FetchLimit; page++ {
+		// Read subset of dataset
+		datasetQueryResult, err = client.GetAITrainedList(ctx, datasetID, "", sessionKey, limit, page)
+		if err != nil {
+			return datasetContent, invalidIDs, err
 		}
 
-		return nil
-	},
+		// Update limit to determine if we do another fetch
+		limit = len(datasetQueryResult)
+
+		// Convert GraphQL Results into internal format
+		converter := &DatasetEntry{}
+		for _, data := range datasetQueryResult {
+			entry, errConvert := converter.FromAITrained(data)
+			if errConvert != nil {
+				fmt.Println(fmt.Sprintf("WARNING: Skipping remote dataset entry: %v", errConvert))
+				invalidIDs = append(invalidIDs, string(data.ID))
+				continue
+			}
+			datasetContent = readInDatasetEntry(datasetContent, entry)
+		}
+
+		if limit >= constants.FetchLimit {
+			// Print intermediate amount of fetched entries
+			fmt.Println(fmt.Sprintf("fetched %v dataset entries...", len(datasetContent)))
+		}
+	}
+
+	return datasetContent, invalidIDs, nil
 }
 
 // orderDatasetEntries orders entries by user messages and condition set.
@@ -154,7 +196,7 @@ func orderDatasetEntries(store []DatasetEntry) []DatasetEntry {
 	for _, entries := range entryGroupMap {
 		entryRankingMap := make(map[int][]DatasetEntry)
 		for _, entry := range entries {
-			conditionVars := strings.Split(entry.Condition, "")
+			condition := entry.Condition
 			ranking := 0x00000 // Use bitwise to avoid 4k+ iterations for each entry
 
 			// Emotion
@@ -166,21 +208,21 @@ func orderDatasetEntries(store []DatasetEntry) []DatasetEntry {
 			}
 			// Attachment
 			for i, key := range attachmentKeyIdx {
-				if conditionVars[2] == key {
+				if condition.Attachment.Key() == key {
 					ranking += 0x01000 * i
 					break
 				}
 			}
 			// Daytime
 			for i, key := range daytimeKeyIdx {
-				if conditionVars[0] == key {
+				if condition.Daytime.Key() == key {
 					ranking += 0x00100 * i
 					break
 				}
 			}
 			// LastSeen
 			for i, key := range lastSeenKeyIdx {
-				if conditionVars[1] == key {
+				if condition.LastSeen.Key() == key {
 					ranking += 0x00010 * i
 					break
 				}
@@ -195,7 +237,7 @@ func orderDatasetEntries(store []DatasetEntry) []DatasetEntry {
 				rankedEntries = []DatasetEntry{entry}
 				entryRankingMap[ranking] = rankedEntries
 			} else {
-				entryRankingMap[ranking] = append(rankedEntries)
+				entryRankingMap[ranking] = append(rankedEntries, entry)
 			}
 		}
 
@@ -220,6 +262,8 @@ func orderDatasetEntries(store []DatasetEntry) []DatasetEntry {
 
 func init() {
 	datasetCmd.AddCommand(downloadCmd)
+
+	downloadCmd.Flags().BoolVar(&writeMeta, "meta", false, "also write dataset metadata and documents into '<target>"+datasetMetaSuffix+"'")
 }
 
 func validateDownloadSource(source string) (string, error) {
@@ -227,7 +271,7 @@ func validateDownloadSource(source string) (string, error) {
 		return "", errors.New("empty source")
 	}
 
-	return source, nil
+	return parseDatasetID(source), nil
 }
 
 func validateDownloadTarget(target string) (string, error) {
@@ -1 +1,162 @@
 package cmd
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/runtimeracer/go-graphql-client"
+	"github.com/runtimeracer/kajitool/query"
+)
+
+func TestDownload(t *testing.T) {
+	env := newTestEnvironment(t)
+
+	// More than one page of entries
+	entries := make([]DatasetEntry, 0)
+	for i := 0; i < 150; i++ {
+		entries = append(entries, newTestEntry("", fmt.Sprintf("Question %v", i), fmt.Sprintf("Answer %v", i)))
+	}
+	env.addDataset("ds1", testUserID, 0, entries...)
+
+	target := env.path("dataset.csv")
+	if err := env.execute("dataset", "download", "-s", "https://kajiwoto.com/d/ds1", "-t", target); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := readDataset(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != len(entries) {
+		t.Fatalf("expected %v entries, got %v", len(entries), len(result))
+	}
+	if diff := diffDatasetEntries(entries, result); len(diff.Added) > 0 || len(diff.Removed) > 0 {
+		t.Errorf("downloaded entries don't match: %v added, %v removed", len(diff.Added), len(diff.Removed))
+	}
+}
+
+func TestDownloadMarksDuplicates(t *testing.T) {
+	env := newTestEnvironment(t)
+	env.addDataset("ds1", testUserID, 0,
+		newTestEntry("a", "Hello", "Hi"),
+		newTestEntry("b", "Hello", "Hi"),
+	)
+
+	target := env.path("dataset.json")
+	if err := env.execute("dataset", "download", "-s", "ds1", "-t", target); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := readDataset(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range result {
+		if len(entry.DuplicateIDs) == 0 {
+			t.Errorf("entry %v is not marked as duplicate", entry.ID)
+		}
+	}
+}
+
+func TestDownloadForeignPaidDataset(t *testing.T) {
+	env := newTestEnvironment(t)
+	env.addDataset("ds1", "someone-else", 100, newTestEntry("", "Hello", "Hi"))
+
+	if err := env.execute("dataset", "download", "-s", "ds1", "-t", env.path("dataset.csv")); err == nil {
+		t.Fatal("expected download of a foreign paid dataset to fail")
+	}
+}
+
+func TestDownloadInvalidRemoteEntries(t *testing.T) {
+	env := newTestEnvironment(t)
+	env.addDataset("ds1", testUserID, 0, newTestEntry("a", "Hello", "Hi"), newTestEntry("b", "Bye", "See you"), newTestEntry("c", "How are you?", "Fine"))
+	state := env.client.State()
+	state.Datasets[0].Entries[0].Condition = "0x000"
+	state.Datasets[0].Entries[1].ASM = "GIGGLY"
+	env.client.SetState(state)
+
+	// Entries with a malformed condition or an unknown emotion are skipped instead of turned into defaults
+	target := env.path("dataset.csv")
+	if err := env.execute("dataset", "download", "-s", "ds1", "-t", target); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := readDataset(target)
+	if err != nil {
+		t.Fatalf("expected the downloaded dataset to be readable: %v", err)
+	}
+	if len(entries) != 1 || entries[0].ID != "c" {
+		t.Errorf("expected only the valid entry to be downloaded, got %+v", entries)
+	}
+}
+
+func TestDownloadWithMeta(t *testing.T) {
+	env := newTestEnvironment(t)
+	env.addDataset("ds1", testUserID, 0, newTestEntry("", "Hello", "Hi"))
+	state := env.client.State()
+	state.Datasets[0].Info.Description = "A test dataset"
+	state.Datasets[0].Info.Tags = []graphql.String{"test"}
+	state.Datasets[0].Info.Documents = []query.AIDocument{
+		{ID: "doc1", Order: 0, Title: "Backstory", Content: "Once upon a time", QueueStatus: "BUILT"},
+		{ID: "doc2", Order: 1, Title: "Friends", Content: "Everyone", QueueStatus: "QUEUED"},
+	}
+	env.client.SetState(state)
+
+	target := env.path("dataset.sqlite")
+	if err := env.execute("dataset", "download", "-s", "ds1", "-t", target, "--meta"); err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := readDatasetMeta(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.ID != "ds1" || meta.Description != "A test dataset" || len(meta.Tags) != 1 || meta.Owner.ID != testUserID {
+		t.Errorf("unexpected metadata: %+v", meta)
+	}
+	if len(meta.Documents) != 2 || meta.Documents[0].Title != "Backstory" || meta.Documents[1].Content != "Everyone" {
+		t.Errorf("unexpected documents: %+v", meta.Documents)
+	}
+
+	// Structured formats store documents along with the entries
+	db, err := openSQLite(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var title string
+	if err = db.QueryRow("SELECT title FROM documents WHERE id = 'doc2'").Scan(&title); err != nil {
+		t.Fatal(err)
+	}
+	if title != "Friends" {
+		t.Errorf("expected document title 'Friends', got %q", title)
+	}
+}
+
+func TestOrderDatasetEntriesKeepsEqualRanks(t *testing.T) {
+	entries := []DatasetEntry{
+		newTestEntry("a", "Hello", "Hi"),
+		newTestEntry("b", "Hello", "Hey"),
+		newTestEntry("c", "Hello", "Good day"),
+		newTestEntry("d", "Hello", "Hi again", "Hello"),
+		newTestEntry("e", "Bye", "See you"),
+	}
+
+	result := orderDatasetEntries(entries)
+	if len(result) != len(entries) {
+		t.Fatalf("expected %v entries, got %v", len(entries), len(result))
+	}
+	if diff := diffDatasetEntries(entries, result); diff.hasChanges() {
+		t.Errorf("ordering changed the entries: %v added, %v removed", len(diff.Added), len(diff.Removed))
+	}
+
+	// Follow-ups are ranked after entries without history
+	positions := make(map[string]int)
+	for i, entry := range result {
+		positions[entry.ID] = i
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		if positions[id] > positions["d"] {
+			t.Errorf("expected entry %v to be ordered before follow-up d: %v", id, entryIDs(result))
+		}
+	}
+}
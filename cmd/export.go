@@ -0,0 +1,203 @@
+// Package cmd
+/*
+Copyright © 2021 NAME HERE runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+
+	"github.com/runtimeracer/kajitool/util"
+	"github.com/spf13/cobra"
+)
+
+const (
+	chatRoleSystem    = "system"
+	chatRoleUser      = "user"
+	chatRoleAssistant = "assistant"
+)
+
+// Flags
+var systemPrompt string
+var skipDeleted, skipDuplicates bool
+
+// exportCmd represents the export command
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Exports a dataset as chat fine-tuning data in JSON Lines format.",
+	Long: `export converts the entries of the specified source dataset into chat fine-tuning records and saves them into the specified target file.
+
+Each entry results in one line containing a {"messages": [...]} record. The history of an entry is added as preceding user turns,
+followed by the user message and the message of the entry as assistant turn.
+
+A system prompt can be added to each record using --system-prompt. It is parsed as Go template and may use the following fields:
+{{.ASM}}, {{.Emotion}}, {{.Daytime}}, {{.LastSeen}}, {{.Attachment}}
+Example: --system-prompt "You are a friendly companion. Your mood: {{.Emotion}}. Time of day: {{.Daytime}}."
+
+param source: a local file (csv, json, jsonl or sqlite), a full Kajiwoto dataset URL (including ID), or a Kajiwoto dataset ID.
+param target: must be a local file. Data will be saved in JSON Lines format.`,
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+
+		// Sanity checks
+		if source, err = validateExportSource(source); err != nil {
+			return err
+		}
+		if target, err = validateExportTarget(target); err != nil {
+			return err
+		}
+
+		var promptTemplate *template.Template
+		if systemPrompt != "" {
+			if promptTemplate, err = template.New("system-prompt").Option("missingkey=error").Parse(systemPrompt); err != nil {
+				return fmt.Errorf("invalid system prompt template: %v", err)
+			}
+		}
+
+		// Read data from source
+		var datasetContent []DatasetEntry
+		var sourceLabel string
+		if datasetContent, sourceLabel, err = loadDataset(cmd.Context(), source); err != nil {
+			return err
+		}
+		fmt.Println(fmt.Sprintf("Read %v entries from %v", len(datasetContent), sourceLabel))
+
+		// Convert into chat records
+		records := make([]chatExportRecord, 0, len(datasetContent))
+		exported := make([]DatasetEntry, 0, len(datasetContent))
+		skipped := 0
+		for _, entry := range datasetContent {
+			entry := entry
+			// Of duplicates, only the first exported entry is kept
+			if (skipDeleted && entry.Deleted) || (skipDuplicates && len(entry.DuplicateIDs) > 0 && findDuplicateEntry(&entry, exported) >= 0) {
+				skipped++
+				continue
+			}
+			exported = append(exported, entry)
+
+			var record chatExportRecord
+			if record, err = entry.ToChatExport(promptTemplate); err != nil {
+				return err
+			}
+			records = append(records, record)
+		}
+
+		// Write to target file
+		if err = writeChatExport(target, records); err != nil {
+			return err
+		}
+
+		fmt.Println(fmt.Sprintf("Done. Exported %v records, skipped %v entries.", len(records), skipped))
+		return nil
+	},
+}
+
+func init() {
+	datasetCmd.AddCommand(exportCmd)
+
+	// Flags for export
+	exportCmd.Flags().StringVar(&systemPrompt, "system-prompt", "", "system prompt template added to each record")
+	exportCmd.Flags().BoolVar(&skipDeleted, "skip-deleted", false, "skip deleted entries")
+	exportCmd.Flags().BoolVar(&skipDuplicates, "skip-duplicates", false, "skip duplicates of entries already exported")
+}
+
+func validateExportSource(source string) (string, error) {
+	if source == "" {
+		return "", errors.New("empty source")
+	}
+
+	return source, nil
+}
+
+func validateExportTarget(target string) (string, error) {
+	if target == "" {
+		return "", errors.New("empty target")
+	}
+
+	return target, nil
+}
+
+// chatExportRecord is a single fine-tuning record
+type chatExportRecord struct {
+	Messages []chatExportMessage `json:"messages"`
+}
+
+// chatExportMessage is a single turn of a fine-tuning record
+type chatExportMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// chatExportPromptData holds the fields available in system prompt templates
+type chatExportPromptData struct {
+	// ASM is the raw emotional key, e.g. HAPPY
+	ASM string
+	// Emotion, Daytime, LastSeen and Attachment are the readable condition names, e.g. daytime_morning
+	Emotion    string
+	Daytime    string
+	LastSeen   string
+	Attachment string
+}
+
+// ToChatExport converts a Dataset entry into a fine-tuning record.
+// The system prompt is only added if a template is provided.
+func (e *DatasetEntry) ToChatExport(promptTemplate *template.Template) (chatExportRecord, error) {
+	messages := make([]chatExportMessage, 0, len(e.History)+3)
+
+	if promptTemplate != nil {
+		data := chatExportPromptData{
+			ASM:        e.ASM,
+			Emotion:    asmMap[e.ASM],
+			Daytime:    e.Condition.Daytime.Name(),
+			LastSeen:   e.Condition.LastSeen.Name(),
+			Attachment: e.Condition.Attachment.Name(),
+		}
+
+		prompt := &strings.Builder{}
+		if err := promptTemplate.Execute(prompt, data); err != nil {
+			return chatExportRecord{}, fmt.Errorf("unable to render system prompt for dataset entry '%v': %v", e.ID, err)
+		}
+		messages = append(messages, chatExportMessage{Role: chatRoleSystem, Content: prompt.String()})
+	}
+
+	for _, history := range e.History {
+		messages = append(messages, chatExportMessage{Role: chatRoleUser, Content: history})
+	}
+	messages = append(messages,
+		chatExportMessage{Role: chatRoleUser, Content: e.UserMessage},
+		chatExportMessage{Role: chatRoleAssistant, Content: e.Message},
+	)
+
+	return chatExportRecord{Messages: messages}, nil
+}
+
+// writeChatExport writes fine-tuning records to a JSON Lines file
+func writeChatExport(target string, records []chatExportRecord) error {
+	return util.WriteFileAtomic(target, func(file io.Writer) error {
+		writer := bufio.NewWriter(file)
+		encoder := json.NewEncoder(writer)
+		for _, record := range records {
+			if err := encoder.Encode(record); err != nil {
+				return err
+			}
+		}
+		return writer.Flush()
+	})
+}
@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestExport(t *testing.T) {
+	env := newTestEnvironment(t)
+
+	deleted := newTestEntry("c", "Old", "Gone")
+	deleted.Deleted = true
+	happy := newTestEntry("b", "Really?", "Yes!", "Hello", "Hi")
+	happy.ASM = "HAPPY"
+	happy.Condition.Daytime = DaytimeMorning
+
+	source := env.path("dataset.json")
+	if err := writeDataset(source, []DatasetEntry{
+		newTestEntry("a", "Hello", "Hi"),
+		happy,
+		deleted,
+		newTestEntry("d", "Bye", "See you"),
+		newTestEntry("e", "Bye", "See you"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{
+			name: "all entries",
+			want: `{"messages":[{"role":"user","content":"Hello"},{"role":"assistant","content":"Hi"}]}
+{"messages":[{"role":"user","content":"Hello"},{"role":"user","content":"Hi"},{"role":"user","content":"Really?"},{"role":"assistant","content":"Yes!"}]}
+{"messages":[{"role":"user","content":"Old"},{"role":"assistant","content":"Gone"}]}
+{"messages":[{"role":"user","content":"Bye"},{"role":"assistant","content":"See you"}]}
+{"messages":[{"role":"user","content":"Bye"},{"role":"assistant","content":"See you"}]}
+`,
+		},
+		{
+			name: "system prompt, skipping deleted entries and duplicates",
+			args: []string{"--system-prompt", "Mood: {{.Emotion}} ({{.ASM}}), {{.Daytime}}", "--skip-deleted", "--skip-duplicates"},
+			want: `{"messages":[{"role":"system","content":"Mood: emotion_any (none), daytime_any"},{"role":"user","content":"Hello"},{"role":"assistant","content":"Hi"}]}
+{"messages":[{"role":"system","content":"Mood: emotion_happy_or_excited (HAPPY), daytime_morning"},{"role":"user","content":"Hello"},{"role":"user","content":"Hi"},{"role":"user","content":"Really?"},{"role":"assistant","content":"Yes!"}]}
+{"messages":[{"role":"system","content":"Mood: emotion_any (none), daytime_any"},{"role":"user","content":"Bye"},{"role":"assistant","content":"See you"}]}
+`,
+		},
+		{
+			name: "first of duplicates only",
+			args: []string{"--skip-duplicates"},
+			want: `{"messages":[{"role":"user","content":"Hello"},{"role":"assistant","content":"Hi"}]}
+{"messages":[{"role":"user","content":"Hello"},{"role":"user","content":"Hi"},{"role":"user","content":"Really?"},{"role":"assistant","content":"Yes!"}]}
+{"messages":[{"role":"user","content":"Old"},{"role":"assistant","content":"Gone"}]}
+{"messages":[{"role":"user","content":"Bye"},{"role":"assistant","content":"See you"}]}
+`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			target := env.path("export.jsonl")
+			if err := env.execute(append([]string{"dataset", "export", "-s", source, "-t", target}, test.args...)...); err != nil {
+				t.Fatal(err)
+			}
+			content, err := ioutil.ReadFile(target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != test.want {
+				t.Errorf("unexpected export:\n%v\nwant:\n%v", string(content), test.want)
+			}
+		})
+	}
+}
+
+func TestExportRemoteDataset(t *testing.T) {
+	env := newTestEnvironment(t)
+	env.addDataset("ds1", testUserID, 0, newTestEntry("a", "Hello", "Hi"))
+
+	target := env.path("export.jsonl")
+	if err := env.execute("dataset", "export", "-s", "https://kajiwoto.com/d/ds1", "-t", target); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"messages":[{"role":"user","content":"Hello"},{"role":"assistant","content":"Hi"}]}` + "\n"; string(content) != want {
+		t.Errorf("unexpected export %q, want %q", content, want)
+	}
+}
+
+func TestExportInvalidSystemPrompt(t *testing.T) {
+	env := newTestEnvironment(t)
+
+	source := env.path("dataset.csv")
+	if err := writeDataset(source, []DatasetEntry{newTestEntry("a", "Hello", "Hi")}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, prompt := range []string{"{{.Emotion", "{{.Unknown}}"} {
+		if err := env.execute("dataset", "export", "-s", source, "-t", env.path("export.jsonl"), "--system-prompt", prompt); err == nil {
+			t.Errorf("expected system prompt %q to be rejected", prompt)
+		}
+	}
+}
@@ -0,0 +1,305 @@
+// Package cmd
+/*
+Copyright © 2021 NAME HERE runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/runtimeracer/kajitool/util"
+)
+
+const (
+	formatCSV       = "csv"
+	formatJSON      = "json"
+	formatJSONLines = "jsonl"
+	formatSQLite    = "sqlite"
+
+	// maxJSONLineSize limits the size of a single line when reading JSON Lines files
+	maxJSONLineSize = 16 * 1024 * 1024
+)
+
+// datasetFormat reads and writes dataset entries from and to local files
+type datasetFormat interface {
+	Read(source string) ([]DatasetEntry, error)
+	Write(target string, entries []DatasetEntry) error
+}
+
+// datasetFormats holds all supported file formats by name
+var datasetFormats = map[string]datasetFormat{
+	formatCSV:       csvFormat{},
+	formatJSON:      jsonFormat{},
+	formatJSONLines: jsonLinesFormat{},
+	formatSQLite:    sqliteFormat{},
+}
+
+// datasetFormatExtensions maps additional file extensions to their format name
+var datasetFormatExtensions = map[string]string{
+	"sqlite3": formatSQLite,
+	"db":      formatSQLite,
+}
+
+// getDatasetFormat returns the format for a local file.
+// The format flag takes precedence; otherwise the format is determined by file extension, falling back to csv.
+func getDatasetFormat(path string) (datasetFormat, error) {
+	if formatName != "" {
+		format, ok := datasetFormats[strings.ToLower(formatName)]
+		if !ok {
+			return nil, fmt.Errorf("unsupported format '%v', must be one of: %v", formatName, strings.Join(getDatasetFormatNames(), ", "))
+		}
+		return format, nil
+	}
+
+	extension := strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+	if name, ok := datasetFormatExtensions[extension]; ok {
+		extension = name
+	}
+	if format, ok := datasetFormats[extension]; ok {
+		return format, nil
+	}
+	return datasetFormats[formatCSV], nil
+}
+
+// getDatasetFormatNames returns the sorted names of all supported formats
+func getDatasetFormatNames() []string {
+	names := make([]string, 0, len(datasetFormats))
+	for name := range datasetFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// readDataset reads dataset entries from a local file in the matching format
+func readDataset(source string) ([]DatasetEntry, error) {
+	format, err := getDatasetFormat(source)
+	if err != nil {
+		return nil, err
+	}
+	return format.Read(source)
+}
+
+// writeDataset writes dataset entries to a local file in the matching format
+func writeDataset(target string, entries []DatasetEntry) error {
+	format, err := getDatasetFormat(target)
+	if err != nil {
+		return err
+	}
+	return format.Write(target, entries)
+}
+
+// csvFormat stores one entry per line, see DatasetEntry.ToCSV for the column mapping
+type csvFormat struct{}
+
+func (f csvFormat) Read(source string) ([]DatasetEntry, error) {
+	return readCSV(source)
+}
+
+func (f csvFormat) Write(target string, entries []DatasetEntry) error {
+	return writeCSV(target, entries)
+}
+
+// jsonFormat stores all entries in a single JSON array
+type jsonFormat struct{}
+
+func (f jsonFormat) Read(source string) ([]DatasetEntry, error) {
+	content, err := ioutil.ReadFile(source)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]datasetEntryJSON, 0)
+	if err = json.Unmarshal(content, &records); err != nil {
+		return nil, err
+	}
+
+	// Create new Dataset store and read in entries
+	converter := &DatasetEntry{}
+	entries := make([]DatasetEntry, 0)
+	for _, record := range records {
+		entry, errConvert := converter.FromJSON(record)
+		if errConvert != nil {
+			return nil, errConvert
+		}
+		entries = readInDatasetEntry(entries, entry)
+	}
+	return entries, nil
+}
+
+func (f jsonFormat) Write(target string, entries []DatasetEntry) error {
+	records := make([]datasetEntryJSON, len(entries))
+	for i, entry := range entries {
+		records[i] = entry.ToJSON()
+	}
+
+	content, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(target, func(file io.Writer) error {
+		_, errWrite := file.Write(content)
+		return errWrite
+	})
+}
+
+// jsonLinesFormat stores one JSON object per line
+type jsonLinesFormat struct{}
+
+func (f jsonLinesFormat) Read(source string) ([]DatasetEntry, error) {
+	file, err := os.Open(source)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if errClose := file.Close(); errClose != nil {
+			fmt.Println("Warn: Unable to close file handle")
+		}
+	}()
+
+	// Create new Dataset store and read in entries
+	converter := &DatasetEntry{}
+	entries := make([]DatasetEntry, 0)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), maxJSONLineSize)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		record := datasetEntryJSON{}
+		if errParse := json.Unmarshal(scanner.Bytes(), &record); errParse != nil {
+			return nil, fmt.Errorf("error parsing JSON line %v: %v", line, errParse)
+		}
+		entry, errConvert := converter.FromJSON(record)
+		if errConvert != nil {
+			return nil, fmt.Errorf("error parsing JSON line %v: %v", line, errConvert)
+		}
+		entries = readInDatasetEntry(entries, entry)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (f jsonLinesFormat) Write(target string, entries []DatasetEntry) error {
+	return util.WriteFileAtomic(target, func(file io.Writer) error {
+		writer := bufio.NewWriter(file)
+		encoder := json.NewEncoder(writer)
+		for _, entry := range entries {
+			if err := encoder.Encode(entry.ToJSON()); err != nil {
+				return err
+			}
+		}
+		return writer.Flush()
+	})
+}
+
+// datasetEntryJSON is the representation of a dataset entry in structured formats
+type datasetEntryJSON struct {
+	ID           string               `json:"id"`
+	UserMessage  string               `json:"userMessage"`
+	Message      string               `json:"message"`
+	ASM          string               `json:"asm"`
+	Condition    datasetConditionJSON `json:"condition"`
+	Deleted      bool                 `json:"deleted"`
+	History      []string             `json:"history"`
+	DuplicateIDs []string             `json:"duplicateIds"`
+}
+
+// datasetConditionJSON holds the condition components of a dataset entry
+type datasetConditionJSON struct {
+	Daytime    string `json:"daytime"`
+	LastSeen   string `json:"lastSeen"`
+	Attachment string `json:"attachment"`
+}
+
+// ToJSON converts a Dataset entry into its structured representation.
+// ASM and condition components are stored by their readable names, same as in CSV files.
+func (e *DatasetEntry) ToJSON() datasetEntryJSON {
+	history := e.History
+	if history == nil {
+		history = make([]string, 0)
+	}
+	duplicateIDs := e.DuplicateIDs
+	if duplicateIDs == nil {
+		duplicateIDs = make([]string, 0)
+	}
+
+	return datasetEntryJSON{
+		ID:          e.ID,
+		UserMessage: e.UserMessage,
+		Message:     e.Message,
+		ASM:         asmMap[e.ASM],
+		Condition: datasetConditionJSON{
+			Daytime:    e.Condition.Daytime.Name(),
+			LastSeen:   e.Condition.LastSeen.Name(),
+			Attachment: e.Condition.Attachment.Name(),
+		},
+		Deleted:      e.Deleted,
+		History:      history,
+		DuplicateIDs: duplicateIDs,
+	}
+}
+
+// FromJSON converts the structured representation of a dataset entry into a Dataset entry
+func (e *DatasetEntry) FromJSON(src datasetEntryJSON) (DatasetEntry, error) {
+	asm, err := lookupMapKey(asmMap, src.ASM, "emotional", src.ID)
+	if err != nil {
+		return DatasetEntry{}, err
+	}
+	condition, err := ParseConditionNames(src.Condition.Daytime, src.Condition.LastSeen, src.Condition.Attachment)
+	if err != nil {
+		return DatasetEntry{}, fmt.Errorf("%v for dataset entry '%v'", err, src.ID)
+	}
+
+	var history, duplicateIDs []string
+	if len(src.History) > 0 {
+		history = src.History
+	}
+	if len(src.DuplicateIDs) > 0 {
+		duplicateIDs = src.DuplicateIDs
+	}
+
+	return DatasetEntry{
+		ID:           src.ID,
+		UserMessage:  src.UserMessage,
+		Message:      src.Message,
+		ASM:          asm,
+		Condition:    condition,
+		Deleted:      src.Deleted,
+		History:      history,
+		DuplicateIDs: duplicateIDs,
+	}, nil
+}
+
+// lookupMapKey returns the key for a readable value, e.g. of an emotion.
+// Unknown values are rejected, same as unknown condition names.
+func lookupMapKey(input map[string]string, value, name, entryID string) (string, error) {
+	for key, val := range input {
+		if val == value {
+			return key, nil
+		}
+	}
+	return "", fmt.Errorf("invalid %v key %v for dataset entry '%v'", name, value, entryID)
+}
@@ -0,0 +1,304 @@
+// Package cmd
+/*
+Copyright © 2021 NAME HERE runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/runtimeracer/kajitool/query"
+	_ "modernc.org/sqlite" // Pure Go SQLite driver, registers as "sqlite"
+)
+
+const (
+	sqliteDriverName = "sqlite"
+
+	/*
+		sqliteSchema describes the normalized storage of a dataset:
+		- dataset:    info on the dataset the entries have been downloaded from (single row)
+		- documents:  documents attached to the dataset, in order
+		- entries:    one row per dataset entry; ASM and conditions are stored by their readable names
+		- history:    preceding user dialogues of an entry, in order
+		- duplicates: IDs of entries with identical content
+	*/
+	sqliteSchema = `
+CREATE TABLE IF NOT EXISTS dataset (
+	id             TEXT PRIMARY KEY,
+	name           TEXT NOT NULL,
+	description    TEXT NOT NULL,
+	count          INTEGER NOT NULL,
+	deleted        INTEGER NOT NULL,
+	nsfw           INTEGER NOT NULL,
+	price          INTEGER NOT NULL,
+	purchased      INTEGER NOT NULL,
+	status         TEXT NOT NULL,
+	tags           TEXT NOT NULL,
+	owner_id       TEXT NOT NULL,
+	owner_username TEXT NOT NULL,
+	updated_at     INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS documents (
+	id           TEXT PRIMARY KEY,
+	position     INTEGER NOT NULL,
+	title        TEXT NOT NULL,
+	content      TEXT NOT NULL,
+	queue_status TEXT NOT NULL,
+	queued_at    INTEGER NOT NULL,
+	built_at     INTEGER NOT NULL,
+	created_at   INTEGER NOT NULL,
+	updated_at   INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS entries (
+	entry_key    INTEGER PRIMARY KEY,
+	id           TEXT NOT NULL,
+	user_message TEXT NOT NULL,
+	message      TEXT NOT NULL,
+	asm          TEXT NOT NULL,
+	daytime      TEXT NOT NULL,
+	last_seen    TEXT NOT NULL,
+	attachment   TEXT NOT NULL,
+	deleted      INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS entries_id ON entries (id);
+CREATE TABLE IF NOT EXISTS history (
+	entry_key INTEGER NOT NULL REFERENCES entries (entry_key) ON DELETE CASCADE,
+	position  INTEGER NOT NULL,
+	message   TEXT NOT NULL,
+	PRIMARY KEY (entry_key, position)
+);
+CREATE TABLE IF NOT EXISTS duplicates (
+	entry_key    INTEGER NOT NULL REFERENCES entries (entry_key) ON DELETE CASCADE,
+	duplicate_id TEXT NOT NULL,
+	PRIMARY KEY (entry_key, duplicate_id)
+);`
+)
+
+// datasetInfoFormat is implemented by formats able to store info on the dataset along with its entries
+type datasetInfoFormat interface {
+	WriteWithInfo(target string, datasetInfo query.AITrainerGroup, entries []DatasetEntry) error
+}
+
+// writeDatasetWithInfo writes dataset entries to a local file in the matching format.
+// Dataset info is stored as well, if the format supports it.
+func writeDatasetWithInfo(target string, datasetInfo query.AITrainerGroup, entries []DatasetEntry) error {
+	format, err := getDatasetFormat(target)
+	if err != nil {
+		return err
+	}
+	if infoFormat, ok := format.(datasetInfoFormat); ok {
+		return infoFormat.WriteWithInfo(target, datasetInfo, entries)
+	}
+	return format.Write(target, entries)
+}
+
+// sqliteFormat stores entries in a normalized SQLite database
+type sqliteFormat struct{}
+
+func (f sqliteFormat) Read(source string) ([]DatasetEntry, error) {
+	// Don't let the driver create an empty database for a missing file
+	if _, err := os.Stat(source); err != nil {
+		return nil, err
+	}
+
+	db, err := openSQLite(source)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if errClose := db.Close(); errClose != nil {
+			fmt.Println("Warn: Unable to close database handle")
+		}
+	}()
+
+	// Read history and duplicates first, grouped by entry
+	historyMap := make(map[int64][]string)
+	if err = querySQLiteList(db, "SELECT entry_key, message FROM history ORDER BY entry_key, position", historyMap); err != nil {
+		return nil, err
+	}
+	duplicateMap := make(map[int64][]string)
+	if err = querySQLiteList(db, "SELECT entry_key, duplicate_id FROM duplicates ORDER BY entry_key, duplicate_id", duplicateMap); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT entry_key, id, user_message, message, asm, daytime, last_seen, attachment, deleted FROM entries ORDER BY entry_key")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Create new Dataset store and read in entries
+	converter := &DatasetEntry{}
+	entries := make([]DatasetEntry, 0)
+	for rows.Next() {
+		var key int64
+		record := datasetEntryJSON{}
+		if err = rows.Scan(&key, &record.ID, &record.UserMessage, &record.Message, &record.ASM,
+			&record.Condition.Daytime, &record.Condition.LastSeen, &record.Condition.Attachment, &record.Deleted); err != nil {
+			return nil, err
+		}
+		record.History = historyMap[key]
+		record.DuplicateIDs = duplicateMap[key]
+		entry, errConvert := converter.FromJSON(record)
+		if errConvert != nil {
+			return nil, errConvert
+		}
+		entries = readInDatasetEntry(entries, entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (f sqliteFormat) Write(target string, entries []DatasetEntry) error {
+	return f.write(target, nil, entries)
+}
+
+func (f sqliteFormat) WriteWithInfo(target string, datasetInfo query.AITrainerGroup, entries []DatasetEntry) error {
+	return f.write(target, &datasetInfo, entries)
+}
+
+// write replaces all entries in the database. Dataset info is only replaced if provided.
+func (f sqliteFormat) write(target string, datasetInfo *query.AITrainerGroup, entries []DatasetEntry) (err error) {
+	db, err := openSQLite(target)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if errClose := db.Close(); errClose != nil && err == nil {
+			err = errClose
+		}
+	}()
+
+	if _, err = db.Exec(sqliteSchema); err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	for _, table := range []string{"duplicates", "history", "entries"} {
+		if _, err = tx.Exec(fmt.Sprintf("DELETE FROM %v", table)); err != nil {
+			return err
+		}
+	}
+
+	if datasetInfo != nil {
+		if err = writeSQLiteDatasetInfo(tx, *datasetInfo); err != nil {
+			return err
+		}
+	}
+
+	for i, entry := range entries {
+		record := entry.ToJSON()
+		key := int64(i + 1)
+		if _, err = tx.Exec("INSERT INTO entries (entry_key, id, user_message, message, asm, daytime, last_seen, attachment, deleted) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			key, record.ID, record.UserMessage, record.Message, record.ASM,
+			record.Condition.Daytime, record.Condition.LastSeen, record.Condition.Attachment, record.Deleted); err != nil {
+			return err
+		}
+		for position, message := range record.History {
+			if _, err = tx.Exec("INSERT INTO history (entry_key, position, message) VALUES (?, ?, ?)", key, position, message); err != nil {
+				return err
+			}
+		}
+		for _, duplicateID := range record.DuplicateIDs {
+			if _, err = tx.Exec("INSERT OR IGNORE INTO duplicates (entry_key, duplicate_id) VALUES (?, ?)", key, duplicateID); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// writeSQLiteDatasetInfo replaces the info on the dataset
+func writeSQLiteDatasetInfo(tx *sql.Tx, datasetInfo query.AITrainerGroup) error {
+	tags := make([]string, len(datasetInfo.Tags))
+	for i, tag := range datasetInfo.Tags {
+		tags[i] = string(tag)
+	}
+	tagsJSON, err := json.Marshal(tags)
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec("DELETE FROM dataset"); err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO dataset (id, name, description, count, deleted, nsfw, price, purchased, status, tags, owner_id, owner_username, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		string(datasetInfo.ID), string(datasetInfo.Name), string(datasetInfo.Description), int(datasetInfo.Count),
+		bool(datasetInfo.Deleted), bool(datasetInfo.NSFW), int(datasetInfo.Price), bool(datasetInfo.Purchased),
+		string(datasetInfo.Status), string(tagsJSON), string(datasetInfo.User.ID), string(datasetInfo.User.Username),
+		datasetInfo.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec("DELETE FROM documents"); err != nil {
+		return err
+	}
+	for _, document := range datasetInfo.Documents {
+		if _, err = tx.Exec("INSERT INTO documents (id, position, title, content, queue_status, queued_at, built_at, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			string(document.ID), int(document.Order), string(document.Title), string(document.Content),
+			string(document.QueueStatus), document.QueuedAt, document.BuiltAt, document.CreatedAt, document.UpdatedAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openSQLite opens a SQLite database with foreign keys enabled
+func openSQLite(path string) (*sql.DB, error) {
+	db, err := sql.Open(sqliteDriverName, path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = db.Exec("PRAGMA foreign_keys = ON"); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// querySQLiteList reads (entry_key, value) rows into a map of value lists
+func querySQLiteList(db *sql.DB, statement string, target map[int64][]string) error {
+	rows, err := db.Query(statement)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key int64
+		var value string
+		if err = rows.Scan(&key, &value); err != nil {
+			return err
+		}
+		target[key] = append(target[key], value)
+	}
+	return rows.Err()
+}
@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/runtimeracer/go-graphql-client"
+	"github.com/runtimeracer/kajitool/query"
+)
+
+func TestSQLiteFormatTables(t *testing.T) {
+	target := filepath.Join(t.TempDir(), "dataset.sqlite")
+	if err := writeDataset(target, newFormatTestEntries()); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := openSQLite(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// History is stored in order, one row per item
+	history := make(map[int64][]string)
+	if err = querySQLiteList(db, "SELECT entry_key, message FROM history ORDER BY entry_key, position", history); err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || !equalStrings(history[1], []string{"Hello", "Hi"}) {
+		t.Errorf("unexpected history rows: %v", history)
+	}
+
+	duplicates := make(map[int64][]string)
+	if err = querySQLiteList(db, "SELECT entry_key, duplicate_id FROM duplicates ORDER BY entry_key, duplicate_id", duplicates); err != nil {
+		t.Fatal(err)
+	}
+	if len(duplicates) != 1 || !equalStrings(duplicates[1], []string{"def", "ghi"}) {
+		t.Errorf("unexpected duplicate rows: %v", duplicates)
+	}
+
+	var asm, daytime string
+	if err = db.QueryRow("SELECT asm, daytime FROM entries WHERE id = 'abc'").Scan(&asm, &daytime); err != nil {
+		t.Fatal(err)
+	}
+	if asm != asmMap["HAPPY"] || daytime != DaytimeEarlyMorningTillMorning.Name() {
+		t.Errorf("expected readable names, got asm %q and daytime %q", asm, daytime)
+	}
+}
+
+func TestSQLiteFormatWriteKeepsDatasetInfo(t *testing.T) {
+	target := filepath.Join(t.TempDir(), "dataset.sqlite")
+	info := query.AITrainerGroup{
+		ID:   "ds1",
+		Name: "Dataset ds1",
+		Tags: []graphql.String{"test"},
+		User: query.User{ID: testUserID, Username: testUsername},
+		Documents: []query.AIDocument{
+			{ID: "doc1", Order: 0, Title: "Backstory", Content: "Once upon a time"},
+		},
+	}
+	if err := writeDatasetWithInfo(target, info, newFormatTestEntries()); err != nil {
+		t.Fatal(err)
+	}
+
+	// A plain write (e.g. after upload) replaces the entries only
+	if err := writeDataset(target, []DatasetEntry{newTestEntry("new", "Hello", "Hi")}); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := readDataset(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].ID != "new" {
+		t.Errorf("expected entries to be replaced, got %v", entries)
+	}
+
+	db, err := openSQLite(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var name, owner, tags string
+	if err = db.QueryRow("SELECT name, owner_username, tags FROM dataset WHERE id = 'ds1'").Scan(&name, &owner, &tags); err != nil {
+		t.Fatal(err)
+	}
+	if name != "Dataset ds1" || owner != testUsername || tags != `["test"]` {
+		t.Errorf("unexpected dataset row: %q, %q, %q", name, owner, tags)
+	}
+	var title string
+	if err = db.QueryRow("SELECT title FROM documents WHERE id = 'doc1'").Scan(&title); err != nil {
+		t.Fatal(err)
+	}
+	if title != "Backstory" {
+		t.Errorf("expected document title 'Backstory', got %q", title)
+	}
+
+	// No rows of the replaced entries are left behind
+	for _, table := range []string{"history", "duplicates"} {
+		var count int
+		if err = db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		if count != 0 {
+			t.Errorf("expected no %v rows, found %v", table, count)
+		}
+	}
+}
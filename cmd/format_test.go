@@ -0,0 +1,198 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// newFormatTestEntries creates entries covering all fields, including nil and empty lists
+func newFormatTestEntries() []DatasetEntry {
+	full := DatasetEntry{
+		ID:           "abc",
+		UserMessage:  "How are you?",
+		Message:      "I'm fine, \"really\"",
+		ASM:          "HAPPY",
+		Condition:    Condition{Daytime: DaytimeEarlyMorningTillMorning, LastSeen: LastSeen2HoursAgo, Attachment: AttachmentLiked},
+		Deleted:      true,
+		History:      []string{"Hello", "Hi"},
+		DuplicateIDs: []string{"def", "ghi"},
+	}
+	empty := newTestEntry("", "Line 1\nLine 2", "Unicode: äöü 🎉")
+	empty.History = make([]string, 0)
+	empty.DuplicateIDs = make([]string, 0)
+	return []DatasetEntry{full, empty, newTestEntry("xyz", "Bye", "See you")}
+}
+
+func TestDatasetFormatRoundTrip(t *testing.T) {
+	for _, name := range getDatasetFormatNames() {
+		t.Run(name, func(t *testing.T) {
+			entries := newFormatTestEntries()
+			target := filepath.Join(t.TempDir(), "dataset."+name)
+			if err := writeDataset(target, entries); err != nil {
+				t.Fatal(err)
+			}
+			result, err := readDataset(target)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Empty lists are read back as nil
+			entries[1].History = nil
+			entries[1].DuplicateIDs = nil
+			if diff := cmp.Diff(entries, result); diff != "" {
+				t.Errorf("unexpected entries after round trip (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestDatasetFormatEmpty(t *testing.T) {
+	for _, name := range getDatasetFormatNames() {
+		t.Run(name, func(t *testing.T) {
+			target := filepath.Join(t.TempDir(), "dataset."+name)
+			if err := writeDataset(target, []DatasetEntry{}); err != nil {
+				t.Fatal(err)
+			}
+			result, err := readDataset(target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(result) != 0 {
+				t.Errorf("expected no entries, got %v", result)
+			}
+		})
+	}
+}
+
+func TestGetDatasetFormat(t *testing.T) {
+	t.Cleanup(func() { formatName = "" })
+
+	tests := []struct {
+		path   string
+		format string
+		want   datasetFormat
+	}{
+		{path: "dataset.csv", want: csvFormat{}},
+		{path: "dataset.json", want: jsonFormat{}},
+		{path: "dataset.JSONL", want: jsonLinesFormat{}},
+		{path: "dataset.sqlite", want: sqliteFormat{}},
+		{path: "dataset.db", want: sqliteFormat{}},
+		{path: "dataset.txt", want: csvFormat{}},
+		{path: "dataset", want: csvFormat{}},
+		{path: "dataset.csv", format: "json", want: jsonFormat{}},
+		{path: "dataset.json", format: "JSONL", want: jsonLinesFormat{}},
+		{path: "dataset.sqlite", format: "csv", want: csvFormat{}},
+	}
+	for _, test := range tests {
+		formatName = test.format
+		got, err := getDatasetFormat(test.path)
+		if err != nil {
+			t.Errorf("getDatasetFormat(%q) with format %q: %v", test.path, test.format, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("getDatasetFormat(%q) with format %q = %T, want %T", test.path, test.format, got, test.want)
+		}
+	}
+
+	formatName = "xml"
+	if _, err := getDatasetFormat("dataset.csv"); err == nil {
+		t.Error("expected unsupported format to fail")
+	}
+}
+
+func TestFormatFlagOverridesExtension(t *testing.T) {
+	t.Cleanup(func() { formatName = "" })
+
+	formatName = formatJSON
+	target := filepath.Join(t.TempDir(), "dataset.csv")
+	if err := writeDataset(target, []DatasetEntry{newTestEntry("a", "Hello", "Hi")}); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(content), "[") {
+		t.Errorf("expected JSON content, got %q", content)
+	}
+
+	entries, err := readDataset(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].ID != "a" {
+		t.Errorf("unexpected entries %v", entries)
+	}
+}
+
+func TestFromJSONInvalidValues(t *testing.T) {
+	valid := newTestEntry("a", "Hello", "Hi")
+
+	tests := map[string]func(record *datasetEntryJSON){
+		"emotion":    func(record *datasetEntryJSON) { record.ASM = "emotion_bored" },
+		"daytime":    func(record *datasetEntryJSON) { record.Condition.Daytime = "daytime_noon" },
+		"last seen":  func(record *datasetEntryJSON) { record.Condition.LastSeen = "" },
+		"attachment": func(record *datasetEntryJSON) { record.Condition.Attachment = "attachment_loved" },
+	}
+	for name, modify := range tests {
+		record := valid.ToJSON()
+		modify(&record)
+		if _, err := valid.FromJSON(record); err == nil {
+			t.Errorf("expected invalid %v to be rejected", name)
+		}
+	}
+}
+
+func TestJSONLinesReportsInvalidLine(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "dataset.jsonl")
+	if err := writeDataset(source, []DatasetEntry{newTestEntry("a", "Hello", "Hi")}); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(source, append(content, []byte("{invalid\n")...), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = readDataset(source); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected error for line 2, got %v", err)
+	}
+}
+
+func TestCSVRejectsInvalidLine(t *testing.T) {
+	valid := newTestEntry("a", "Hello", "Hi")
+	tests := map[string]func(record []string) []string{
+		"length":     func(record []string) []string { return record[:5] },
+		"emotion":    func(record []string) []string { record[3] = "emotion_bored"; return record },
+		"daytime":    func(record []string) []string { record[5] = "daytime_noon"; return record },
+		"deleted":    func(record []string) []string { record[7] = "maybe"; return record },
+		"attachment": func(record []string) []string { record[4] = "attachment_loved"; return record },
+	}
+	for name, modify := range tests {
+		t.Run(name, func(t *testing.T) {
+			source := filepath.Join(t.TempDir(), "dataset.csv")
+			first := newTestEntry("b", "Bye", "See you")
+			lines := [][]string{first.ToCSV(), modify(valid.ToCSV())}
+			content := ""
+			for _, line := range lines {
+				content += strings.Join(line, ",") + "\n"
+			}
+			if err := ioutil.WriteFile(source, []byte(content), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			// Invalid lines fail the whole read, instead of adding empty entries
+			entries, err := readDataset(source)
+			if err == nil || !strings.Contains(err.Error(), "line 2") {
+				t.Errorf("expected error for line 2, got %v entries and error %v", len(entries), err)
+			}
+		})
+	}
+}
@@ -0,0 +1,424 @@
+// Package cmd
+/*
+Copyright © 2021 NAME HERE runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	importFormatConversations = "conversations"
+	importFormatAIML          = "aiml"
+	importFormatChatterBot    = "chatterbot"
+
+	importDefaultASM = "none"
+)
+
+// importDefaultCondition applies imported entries regardless of daytime, last seen and attachment
+var importDefaultCondition = Condition{}
+
+// Flags
+var importFormat string
+
+// importCmd represents the import command
+var importCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Converts a conversational corpus from a specified source file into dataset entries and stores them in a specified target file.",
+	Long: `import converts conversations from common chatbot formats into dataset entries and saves them into the specified target file.
+
+Supported source formats:
+- conversations: ShareGPT ({"conversations": [{"from": "human", "value": ...}]}) or ChatML ({"messages": [{"role": "user", "content": ...}]})
+                 conversation lists, either as JSON array or JSON Lines.
+- aiml:          AIML categories. Random templates result in one entry per response.
+- chatterbot:    ChatterBot YAML corpora. Statements of a conversation are alternately used as user message and response.
+
+Each user message followed by a response becomes an entry. The previous user message of the conversation is stored as its history.
+All entries get the default condition and no emotion (ASM), so they apply in any situation.
+
+param source: must be a local file. Format is determined by file extension (.json, .jsonl, .aiml, .yml, .yaml) or --input-format.
+param target: must be a local file. Data will be saved in csv, json, jsonl or sqlite format, depending on file extension or --format.`,
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+
+		// Sanity checks
+		if source, err = validateImportSource(source); err != nil {
+			return err
+		}
+		if target, err = validateImportTarget(target); err != nil {
+			return err
+		}
+
+		var sourceFormat string
+		if sourceFormat, err = getImportFormat(source); err != nil {
+			return err
+		}
+
+		// Read data from source file
+		var content []byte
+		if content, err = ioutil.ReadFile(source); err != nil {
+			return err
+		}
+
+		// Convert into conversations
+		var conversations []importConversation
+		switch sourceFormat {
+		case importFormatConversations:
+			conversations, err = parseChatConversations(content)
+		case importFormatAIML:
+			conversations, err = parseAIMLCategories(content)
+		case importFormatChatterBot:
+			conversations, err = parseChatterBotCorpus(content)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to parse %v source: %v", sourceFormat, err)
+		}
+
+		// Convert into dataset entries
+		datasetContent := make([]DatasetEntry, 0)
+		for _, conversation := range conversations {
+			for _, entry := range conversation.ToDatasetEntries() {
+				datasetContent = readInDatasetEntry(datasetContent, entry)
+			}
+		}
+		fmt.Println(fmt.Sprintf("Converted %v conversations into %v dataset entries.", len(conversations), len(datasetContent)))
+		if len(datasetContent) == 0 {
+			return fmt.Errorf("no trainable conversations found in %v source %v", sourceFormat, source)
+		}
+
+		// Write to target file
+		if err = writeDataset(target, datasetContent); err != nil {
+			return err
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	datasetCmd.AddCommand(importCmd)
+
+	// Flags for import
+	importCmd.Flags().StringVar(&importFormat, "input-format", "", "format of the source file (conversations, aiml, chatterbot); determined by file extension if not set")
+}
+
+func validateImportSource(source string) (string, error) {
+	if source == "" {
+		return "", errors.New("empty source")
+	}
+
+	return source, nil
+}
+
+func validateImportTarget(target string) (string, error) {
+	if target == "" {
+		return "", errors.New("empty target")
+	}
+
+	return target, nil
+}
+
+// getImportFormat returns the format of an import source file
+func getImportFormat(source string) (string, error) {
+	if importFormat != "" {
+		switch name := strings.ToLower(importFormat); name {
+		case importFormatConversations, importFormatAIML, importFormatChatterBot:
+			return name, nil
+		default:
+			return "", fmt.Errorf("unsupported input format '%v'", importFormat)
+		}
+	}
+
+	switch strings.ToLower(filepath.Ext(source)) {
+	case ".json", ".jsonl":
+		return importFormatConversations, nil
+	case ".aiml", ".xml":
+		return importFormatAIML, nil
+	case ".yml", ".yaml":
+		return importFormatChatterBot, nil
+	default:
+		return "", fmt.Errorf("unable to determine format of '%v', please specify --input-format", source)
+	}
+}
+
+// importTurn is a single message of a conversation
+type importTurn struct {
+	// FromUser tells whether the message has been written by the user or by the bot
+	FromUser bool
+	Content  string
+}
+
+// importConversation is a list of turns in chronological order
+type importConversation []importTurn
+
+// ToDatasetEntries converts a conversation into dataset entries.
+// Every bot message following a user message creates an entry, consecutive messages of the user are joined.
+func (c importConversation) ToDatasetEntries() []DatasetEntry {
+	entries := make([]DatasetEntry, 0)
+	userMessages := make([]string, 0)
+	previousUserMessage := ""
+
+	for _, turn := range c {
+		content := strings.TrimSpace(turn.Content)
+		if content == "" {
+			continue
+		}
+		if turn.FromUser {
+			userMessages = append(userMessages, content)
+			continue
+		}
+		if len(userMessages) == 0 {
+			// Bot message without a preceding user message can't be trained
+			continue
+		}
+
+		var history []string
+		if previousUserMessage != "" {
+			history = []string{previousUserMessage}
+		}
+		userMessage := strings.Join(userMessages, "\n")
+		entries = append(entries, DatasetEntry{
+			UserMessage: userMessage,
+			Message:     content,
+			ASM:         importDefaultASM,
+			Condition:   importDefaultCondition,
+			History:     history,
+		})
+
+		previousUserMessage = userMessage
+		userMessages = userMessages[:0]
+	}
+
+	return entries
+}
+
+// chatConversation holds both the ShareGPT and the ChatML representation of a conversation
+type chatConversation struct {
+	// ShareGPT
+	Conversations []struct {
+		From  string `json:"from"`
+		Value string `json:"value"`
+	} `json:"conversations"`
+	// ChatML
+	Messages []struct {
+		Role    string `json:"role"`
+		Content string `json:"content"`
+	} `json:"messages"`
+}
+
+// parseChatConversations reads ShareGPT or ChatML conversations, either as JSON array or JSON Lines
+func parseChatConversations(content []byte) ([]importConversation, error) {
+	raw := make([]chatConversation, 0)
+	if trimmed := bytes.TrimSpace(content); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &raw); err != nil {
+			return nil, err
+		}
+	} else {
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		for {
+			conversation := chatConversation{}
+			if err := decoder.Decode(&conversation); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			raw = append(raw, conversation)
+		}
+	}
+
+	conversations := make([]importConversation, 0, len(raw))
+	for _, item := range raw {
+		conversation := make(importConversation, 0)
+		for _, turn := range item.Conversations {
+			if fromUser, ok := isUserChatRole(turn.From); ok {
+				conversation = append(conversation, importTurn{FromUser: fromUser, Content: turn.Value})
+			}
+		}
+		for _, turn := range item.Messages {
+			if fromUser, ok := isUserChatRole(turn.Role); ok {
+				conversation = append(conversation, importTurn{FromUser: fromUser, Content: turn.Content})
+			}
+		}
+		conversations = append(conversations, conversation)
+	}
+	return conversations, nil
+}
+
+// isUserChatRole tells whether a ShareGPT / ChatML role belongs to the user.
+// Returns false for ok, if the role is neither user nor bot (e.g. system prompts).
+func isUserChatRole(role string) (fromUser bool, ok bool) {
+	switch strings.ToLower(role) {
+	case "human", "user":
+		return true, true
+	case "gpt", "assistant", "bot", "model":
+		return false, true
+	default:
+		return false, false
+	}
+}
+
+// aimlCategory is a single pattern / template combination of an AIML file
+type aimlCategory struct {
+	Pattern  aimlInner `xml:"pattern"`
+	Template aimlInner `xml:"template"`
+}
+
+// aimlInner holds the raw content of an AIML element
+type aimlInner struct {
+	Content string `xml:",innerxml"`
+}
+
+// parseAIMLCategories reads all categories of an AIML file, including the ones nested in topics
+func parseAIMLCategories(content []byte) ([]importConversation, error) {
+	conversations := make([]importConversation, 0)
+	decoder := xml.NewDecoder(bytes.NewReader(content))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok || strings.ToLower(start.Name.Local) != "category" {
+			continue
+		}
+		category := aimlCategory{}
+		if err = decoder.DecodeElement(&category, &start); err != nil {
+			return nil, err
+		}
+
+		pattern := aimlPlainText(category.Pattern.Content)
+		if pattern == "" || strings.Trim(pattern, "*_ ") == "" {
+			// Wildcard only patterns can't be trained
+			continue
+		}
+		for _, response := range aimlResponses(category.Template.Content) {
+			conversations = append(conversations, importConversation{
+				{FromUser: true, Content: pattern},
+				{FromUser: false, Content: response},
+			})
+		}
+	}
+	return conversations, nil
+}
+
+// aimlResponses returns all possible responses of an AIML template.
+// Each item of a <random> element is a separate response.
+func aimlResponses(template string) []string {
+	responses := make([]string, 0)
+	decoder := xml.NewDecoder(strings.NewReader("<template>" + template + "</template>"))
+	inRandom := false
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		switch element := token.(type) {
+		case xml.StartElement:
+			name := strings.ToLower(element.Name.Local)
+			if name == "random" {
+				inRandom = true
+			} else if name == "li" && inRandom {
+				item := aimlInner{}
+				if errDecode := decoder.DecodeElement(&item, &element); errDecode == nil {
+					if response := aimlPlainText(item.Content); response != "" {
+						responses = append(responses, response)
+					}
+				}
+			}
+		case xml.EndElement:
+			if strings.ToLower(element.Name.Local) == "random" {
+				inRandom = false
+			}
+		}
+	}
+	if len(responses) > 0 {
+		return responses
+	}
+
+	if response := aimlPlainText(template); response != "" {
+		responses = append(responses, response)
+	}
+	return responses
+}
+
+// aimlPlainText strips all markup from AIML content.
+// Content of elements which are not meant to be displayed (e.g. <srai>, <think>) is dropped.
+func aimlPlainText(content string) string {
+	text := &strings.Builder{}
+	decoder := xml.NewDecoder(strings.NewReader("<text>" + content + "</text>"))
+	hidden := 0
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		switch element := token.(type) {
+		case xml.StartElement:
+			switch strings.ToLower(element.Name.Local) {
+			case "srai", "sr", "think", "learn", "system":
+				hidden++
+			}
+		case xml.EndElement:
+			switch strings.ToLower(element.Name.Local) {
+			case "srai", "sr", "think", "learn", "system":
+				hidden--
+			}
+		case xml.CharData:
+			if hidden == 0 {
+				text.Write(element)
+			}
+		}
+	}
+	return strings.Join(strings.Fields(text.String()), " ")
+}
+
+// chatterBotCorpus is the structure of a ChatterBot YAML corpus file
+type chatterBotCorpus struct {
+	Categories    []string   `yaml:"categories"`
+	Conversations [][]string `yaml:"conversations"`
+}
+
+// parseChatterBotCorpus reads a ChatterBot YAML corpus.
+// Statements of a conversation alternate between user and bot, starting with the user.
+func parseChatterBotCorpus(content []byte) ([]importConversation, error) {
+	corpus := chatterBotCorpus{}
+	if err := yaml.Unmarshal(content, &corpus); err != nil {
+		return nil, err
+	}
+
+	conversations := make([]importConversation, 0, len(corpus.Conversations))
+	for _, statements := range corpus.Conversations {
+		conversation := make(importConversation, len(statements))
+		for i, statement := range statements {
+			conversation[i] = importTurn{FromUser: i%2 == 0, Content: statement}
+		}
+		conversations = append(conversations, conversation)
+	}
+	return conversations, nil
+}
@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestImport(t *testing.T) {
+	// newImportEntry creates an entry as created by import
+	newImportEntry := func(userMessage, message string, history ...string) DatasetEntry {
+		entry := newTestEntry("", userMessage, message, history...)
+		entry.Condition = importDefaultCondition
+		return entry
+	}
+
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		args    []string
+		want    []DatasetEntry
+	}{
+		{
+			name: "ShareGPT",
+			file: "sharegpt.json",
+			content: `[{"conversations": [
+				{"from": "system", "value": "You are a bot"},
+				{"from": "human", "value": "Hello"},
+				{"from": "gpt", "value": "Hi"},
+				{"from": "human", "value": "How are you?"},
+				{"from": "human", "value": "Tell me!"},
+				{"from": "gpt", "value": "Fine"}
+			]}]`,
+			want: []DatasetEntry{
+				newImportEntry("Hello", "Hi"),
+				newImportEntry("How are you?\nTell me!", "Fine", "Hello"),
+			},
+		},
+		{
+			name: "ChatML lines",
+			file: "chatml.jsonl",
+			content: `{"messages": [{"role": "assistant", "content": "Welcome"}, {"role": "user", "content": "Hello"}, {"role": "assistant", "content": "Hi"}]}
+{"messages": [{"role": "user", "content": "Bye"}, {"role": "assistant", "content": "  "}, {"role": "assistant", "content": "See you"}]}`,
+			want: []DatasetEntry{
+				newImportEntry("Hello", "Hi"),
+				newImportEntry("Bye", "See you"),
+			},
+		},
+		{
+			name: "AIML",
+			file: "bot.aiml",
+			content: `<?xml version="1.0" encoding="UTF-8"?>
+<aiml version="2.0">
+	<category><pattern>HELLO</pattern><template><random><li>Hi</li><li>Hey <b>there</b></li></random></template></category>
+	<topic name="FOOD">
+		<category><pattern>I AM HUNGRY</pattern><template><think><set name="mood">hungry</set></think>Eat something</template></category>
+	</topic>
+	<category><pattern>*</pattern><template>I don't understand</template></category>
+	<category><pattern>HI</pattern><template><srai>HELLO</srai></template></category>
+</aiml>`,
+			want: []DatasetEntry{
+				newImportEntry("HELLO", "Hi"),
+				newImportEntry("HELLO", "Hey there"),
+				newImportEntry("I AM HUNGRY", "Eat something"),
+			},
+		},
+		{
+			name: "ChatterBot",
+			file: "corpus.yml",
+			content: `categories:
+- greetings
+conversations:
+- - Hello
+  - Hi
+  - How are you?
+  - Fine
+- - Bye
+`,
+			want: []DatasetEntry{
+				newImportEntry("Hello", "Hi"),
+				newImportEntry("How are you?", "Fine", "Hello"),
+			},
+		},
+		{
+			name:    "input format flag",
+			file:    "corpus.txt",
+			content: "conversations:\n- - Hello\n  - Hi\n",
+			args:    []string{"--input-format", "ChatterBot"},
+			want:    []DatasetEntry{newImportEntry("Hello", "Hi")},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			env := newTestEnvironment(t)
+			source := env.path(test.file)
+			if err := ioutil.WriteFile(source, []byte(test.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			target := env.path("dataset.json")
+			if err := env.execute(append([]string{"dataset", "import", "-s", source, "-t", target}, test.args...)...); err != nil {
+				t.Fatal(err)
+			}
+			result, err := readDataset(target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.want, result); diff != "" {
+				t.Errorf("unexpected entries (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestImportInvalidInput(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		args    []string
+	}{
+		{name: "malformed JSON", file: "chat.json", content: `[{"conversations": [`},
+		{name: "malformed JSON Lines", file: "chat.jsonl", content: "{\"messages\": []}\n{invalid}\n"},
+		{name: "JSON without conversations", file: "chat.json", content: `[{"foo": "bar"}]`},
+		{name: "conversation without responses", file: "chat.json", content: `[{"messages": [{"role": "user", "content": "Hello"}]}]`},
+		{name: "malformed AIML", file: "bot.aiml", content: `<aiml><category><pattern>HELLO</pattern>`},
+		{name: "AIML without categories", file: "bot.xml", content: `<note>Hello</note>`},
+		{name: "malformed YAML", file: "corpus.yml", content: "conversations: [[Hello, Hi]"},
+		{name: "unknown extension", file: "corpus.txt", content: "Hello"},
+		{name: "unsupported input format", file: "chat.json", content: "[]", args: []string{"--input-format", "csv"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			env := newTestEnvironment(t)
+			source := env.path(test.file)
+			if err := ioutil.WriteFile(source, []byte(test.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			target := env.path("dataset.csv")
+			if err := env.execute(append([]string{"dataset", "import", "-s", source, "-t", target}, test.args...)...); err == nil {
+				t.Fatal("expected import to fail")
+			}
+			if _, err := readDataset(target); err == nil {
+				t.Error("no target file must be written")
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+// Package cmd
+/*
+Copyright © 2021 NAME HERE runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+// Flags
+var outputFormat string
+
+// infoCmd represents the info command
+var infoCmd = &cobra.Command{
+	Use:   "info",
+	Short: "Prints all information available on a specified source dataset.",
+	Long: `info fetches the info on the specified source dataset and prints it, including owner, price, purchase status,
+status, NSFW flag, tags, personalities, kudos and the list of documents with their queue / build status.
+
+param source: a full Kajiwoto dataset URL (including ID), or a Kajiwoto dataset ID.
+param output: 'table' (default) or 'json'.`,
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+
+		// Sanity checks
+		if source, err = validateInfoSource(source); err != nil {
+			return err
+		}
+		if outputFormat, err = validateInfoOutput(outputFormat); err != nil {
+			return err
+		}
+
+		// Load session key from the credential store
+		if err = loadSessionKey(); err != nil {
+			return err
+		}
+
+		// Init Client
+		client, err := newSessionClient(endpoint)
+		if err != nil {
+			return err
+		}
+
+		// Get Info on the source Dataset
+		datasetInfo, err := client.GetAITrainerGroup(cmd.Context(), source, sessionKey)
+		if err != nil {
+			return err
+		}
+		meta := newDatasetMeta(datasetInfo)
+
+		if outputFormat == outputJSON {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(meta)
+		}
+		return printDatasetInfo(os.Stdout, meta)
+	},
+}
+
+// printDatasetInfo prints the dataset info as a table of properties, followed by a table of its documents
+func printDatasetInfo(w io.Writer, meta datasetMeta) error {
+	personalities := make([]string, len(meta.Personalities))
+	for i, personality := range meta.Personalities {
+		personalities[i] = strings.Join(personality, ", ")
+	}
+
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	rows := [][2]string{
+		{"ID", meta.ID},
+		{"Name", meta.Name},
+		{"Description", meta.Description},
+		{"Owner", fmt.Sprintf("%v (%v)", meta.Owner.Username, meta.Owner.ID)},
+		{"Entries", fmt.Sprintf("%v", meta.Count)},
+		{"Price", fmt.Sprintf("%v", meta.Price)},
+		{"Purchased", fmt.Sprintf("%v", meta.Purchased)},
+		{"Status", meta.Status},
+		{"NSFW", fmt.Sprintf("%v", meta.NSFW)},
+		{"Deleted", fmt.Sprintf("%v", meta.Deleted)},
+		{"Tags", strings.Join(meta.Tags, ", ")},
+		{"Personalities", strings.Join(personalities, "; ")},
+		{"Kudos", fmt.Sprintf("%v upvotes, %v comments (upvoted: %v)", meta.Kudos.Upvotes, meta.Kudos.Comments, meta.Kudos.Upvoted)},
+		{"Updated", formatTimestamp(meta.UpdatedAt)},
+		{"Documents", fmt.Sprintf("%v", len(meta.Documents))},
+	}
+	for _, row := range rows {
+		// Keep multi-line values (e.g. descriptions) in a single table row
+		fmt.Fprintf(table, "%v:\t%v\n", row[0], strings.ReplaceAll(row[1], "\n", " "))
+	}
+	if err := table.Flush(); err != nil {
+		return err
+	}
+
+	if len(meta.Documents) == 0 {
+		return nil
+	}
+	fmt.Fprintln(w)
+	table = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "ORDER\tID\tTITLE\tLENGTH\tSTATUS\tQUEUED\tBUILT\tCREATED\tUPDATED")
+	for _, document := range meta.Documents {
+		fmt.Fprintf(table, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", document.Order, document.ID,
+			strings.ReplaceAll(document.Title, "\n", " "), len(document.Content), document.QueueStatus,
+			formatTimestamp(document.QueuedAt), formatTimestamp(document.BuiltAt),
+			formatTimestamp(document.CreatedAt), formatTimestamp(document.UpdatedAt))
+	}
+	return table.Flush()
+}
+
+// formatTimestamp formats a timestamp returned by the API, which may be given in seconds or milliseconds
+func formatTimestamp(timestamp uint64) string {
+	if timestamp == 0 {
+		return "-"
+	}
+	if timestamp > 1e11 {
+		return time.Unix(0, int64(timestamp)*int64(time.Millisecond)).UTC().Format(time.RFC3339)
+	}
+	return time.Unix(int64(timestamp), 0).UTC().Format(time.RFC3339)
+}
+
+func init() {
+	datasetCmd.AddCommand(infoCmd)
+
+	infoCmd.Flags().StringVarP(&outputFormat, "output", "o", outputTable, "output format (table, json)")
+}
+
+func validateInfoSource(source string) (string, error) {
+	if source == "" {
+		return "", errors.New("empty source")
+	}
+
+	return parseDatasetID(source), nil
+}
+
+func validateInfoOutput(output string) (string, error) {
+	switch strings.ToLower(output) {
+	case "", outputTable:
+		return outputTable, nil
+	case outputJSON:
+		return outputJSON, nil
+	}
+	return "", fmt.Errorf("unknown output format %q, must be one of: %v, %v", output, outputTable, outputJSON)
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/runtimeracer/kajitool/query"
+)
+
+func TestInfo(t *testing.T) {
+	env := newTestEnvironment(t)
+	env.addDataset("ds1", testUserID, 0, newTestEntry("", "Hello", "Hi"))
+
+	if err := env.execute("dataset", "info", "-s", "https://kajiwoto.com/d/ds1"); err != nil {
+		t.Fatal(err)
+	}
+	// The JSON output must not be mixed with any diagnostics
+	output, err := env.executeWithOutput("dataset", "info", "-s", "ds1", "--output", "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var meta datasetMeta
+	if err = json.Unmarshal([]byte(output), &meta); err != nil {
+		t.Fatalf("expected JSON output, got %q: %v", output, err)
+	}
+	if meta.ID != "ds1" || meta.Count != 1 {
+		t.Errorf("unexpected dataset info: %+v", meta)
+	}
+	if err := env.execute("dataset", "info", "-s", "ds1", "--output", "xml"); err == nil {
+		t.Error("expected error on unknown output format")
+	}
+	if err := env.execute("dataset", "info", "-s", "unknown", "--output", "table"); err == nil {
+		t.Error("expected error on unknown dataset")
+	}
+}
+
+func TestPrintDatasetInfo(t *testing.T) {
+	meta := newDatasetMeta(query.AITrainerGroup{
+		ID:          "ds1",
+		Name:        "My Dataset",
+		Description: "First line\nSecond line",
+		Count:       42,
+		Status:      "PUBLISHED",
+		UpdatedAt:   1627819200000,
+		Documents: []query.AIDocument{
+			{ID: "doc1", Order: 1, Title: "Backstory", Content: "Once upon a time", QueueStatus: "BUILT", BuiltAt: 1627819200},
+		},
+	})
+
+	var output bytes.Buffer
+	if err := printDatasetInfo(&output, meta); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"My Dataset", "First line Second line", "42", "PUBLISHED", "2021-08-01T12:00:00Z", "Backstory", "BUILT"} {
+		if !strings.Contains(output.String(), expected) {
+			t.Errorf("expected output to contain %q, got:\n%v", expected, output.String())
+		}
+	}
+}
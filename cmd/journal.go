@@ -0,0 +1,214 @@
+// Package cmd
+/*
+Copyright © 2021 NAME HERE runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	uploadJournalSuffix = ".kajitool-journal"
+)
+
+// uploadJournalRecord is a single line of an upload journal, written for each successfully trained entry
+type uploadJournalRecord struct {
+	DatasetID string `json:"datasetId"`
+	Hash      string `json:"hash"`
+	Count     int    `json:"count"`
+	TrainedAt int64  `json:"trainedAt"`
+}
+
+// uploadJournal keeps track of entries already trained by an upload, so an interrupted upload can be resumed
+type uploadJournal struct {
+	path      string
+	datasetID string
+	file      *os.File
+	// trained holds the amount of trained entries per content hash
+	trained map[string]int
+	// pending holds the amount of trained entries per content hash, which have not been skipped yet
+	pending map[string]int
+}
+
+// uploadJournalPath returns the path of the journal for a source file
+func uploadJournalPath(source string) string {
+	return source + uploadJournalSuffix
+}
+
+// readUploadJournal reads an existing journal without opening it for new records.
+// Fails if the journal belongs to an upload into another dataset.
+func readUploadJournal(path, datasetID string) (*uploadJournal, error) {
+	journal := &uploadJournal{
+		path:      path,
+		datasetID: datasetID,
+		trained:   make(map[string]int),
+		pending:   make(map[string]int),
+	}
+
+	if existing, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(existing)
+		for line := 1; scanner.Scan(); line++ {
+			if len(strings.TrimSpace(scanner.Text())) == 0 {
+				continue
+			}
+			record := uploadJournalRecord{}
+			if errParse := json.Unmarshal(scanner.Bytes(), &record); errParse != nil {
+				// Last line might be incomplete if the upload has been killed while writing it
+				fmt.Println(fmt.Sprintf("Warn: skipping invalid journal line %v: %v", line, errParse))
+				continue
+			}
+			if record.DatasetID != datasetID {
+				_ = existing.Close()
+				return nil, fmt.Errorf("journal %v belongs to an upload into dataset %v", path, record.DatasetID)
+			}
+			journal.trained[record.Hash]++
+			journal.pending[record.Hash]++
+		}
+		errScan := scanner.Err()
+		_ = existing.Close()
+		if errScan != nil {
+			return nil, errScan
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return journal, nil
+}
+
+// openUploadJournal reads an existing journal and opens it for appending new records
+func openUploadJournal(path, datasetID string) (*uploadJournal, error) {
+	journal, err := readUploadJournal(path, datasetID)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	journal.file = file
+	return journal, nil
+}
+
+// skip tells whether an entry has already been trained according to the journal.
+// Each journal record only skips a single entry, so identical entries are handled correctly.
+func (j *uploadJournal) skip(entry *DatasetEntry) bool {
+	hash := datasetEntryHash(entry)
+	if j.pending[hash] > 0 {
+		j.pending[hash]--
+		return true
+	}
+	return false
+}
+
+// contains tells whether an entry with identical content has been trained according to the journal
+func (j *uploadJournal) contains(entry *DatasetEntry) bool {
+	return j.trained[datasetEntryHash(entry)] > 0
+}
+
+// size returns the amount of trained entries recorded in the journal
+func (j *uploadJournal) size() int {
+	size := 0
+	for _, amount := range j.trained {
+		size += amount
+	}
+	return size
+}
+
+// record appends a trained entry to the journal and syncs it to disk
+func (j *uploadJournal) record(entry *DatasetEntry, count int) error {
+	hash := datasetEntryHash(entry)
+	content, err := json.Marshal(uploadJournalRecord{
+		DatasetID: j.datasetID,
+		Hash:      hash,
+		Count:     count,
+		TrainedAt: time.Now().Unix(),
+	})
+	if err != nil {
+		return err
+	}
+	if _, err = j.file.Write(append(content, '\n')); err != nil {
+		return err
+	}
+	if err = j.file.Sync(); err != nil {
+		return err
+	}
+	j.trained[hash]++
+	return nil
+}
+
+// close closes the journal file
+func (j *uploadJournal) close() error {
+	return j.file.Close()
+}
+
+// remove closes and deletes the journal file
+func (j *uploadJournal) remove() error {
+	if err := j.close(); err != nil {
+		return err
+	}
+	return os.Remove(j.path)
+}
+
+// datasetEntryHash creates a hash of the content of an entry, using the same fields as isDuplicate
+func datasetEntryHash(entry *DatasetEntry) string {
+	fields := []string{entry.UserMessage, entry.Message, entry.ASM, entry.Condition.String()}
+	fields = append(fields, entry.History...)
+	sum := sha256.Sum256([]byte(strings.Join(fields, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// assignJournaledIDs sets the IDs of local entries without ID, which have been trained according to the journal,
+// to the IDs of their content-equivalent remote entries. Returns the amount of entries without ID left.
+func assignJournaledIDs(localData, remoteData []DatasetEntry, journal *uploadJournal) (assigned, missing int) {
+	// Remote entries already linked to a local entry can't be claimed again
+	claimed := make(map[string]bool)
+	for i := range localData {
+		if localData[i].ID != "" {
+			claimed[localData[i].ID] = true
+		}
+	}
+
+	for i := range localData {
+		localEntry := &localData[i]
+		if localEntry.ID != "" || !journal.contains(localEntry) {
+			continue
+		}
+		found := false
+		for j := range remoteData {
+			remoteEntry := &remoteData[j]
+			if !claimed[remoteEntry.ID] && localEntry.isDuplicate(remoteEntry) {
+				localEntry.ID = remoteEntry.ID
+				claimed[remoteEntry.ID] = true
+				found = true
+				break
+			}
+		}
+		if found {
+			assigned++
+		} else {
+			missing++
+		}
+	}
+	return assigned, missing
+}
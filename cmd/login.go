@@ -17,15 +17,27 @@ limitations under the License.
 package cmd
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
+	"github.com/runtimeracer/kajitool/constants"
 	"github.com/runtimeracer/kajitool/query"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
 )
 
+// passwordEnv can hold the password used for login, e.g. in CI jobs.
+// It's read from the environment only, never from the config file.
+var passwordEnv = constants.EnvPrefix + "_PASSWORD"
+
 // Flags
 var username, password string
+var showToken, passwordStdin bool
 
 // loginCmd represents the login command
 var loginCmd = &cobra.Command{
@@ -34,29 +46,52 @@ var loginCmd = &cobra.Command{
 	Long: `login takes your kajiwoto login credentials as arguments to do the following things:
 
 1. Login and verify correctness of credentials.
-2. Storing your credentials in your kajitool config file ($HOMEDIR/.kajotool.yaml).
-3. Storing your session ID in your kajitool config file for reuse when executing further commands against the Kajiwoto API.`,
+2. Storing your session key in a credential store for reuse when executing further commands against the Kajiwoto API.
+   By default, it is stored in a file encrypted with a passphrase ($HOMEDIR/.kajitool.credentials). Use --credential-store keyring
+   to store it in the keyring of your operating system instead. Your kajitool config file ($HOMEDIR/.kajitool.yaml) only holds a
+   reference to it.
+3. Storing username, endpoint and session key per profile. Use --profile to manage multiple accounts.
+
+If -u is set, a login via username / password is performed, even if there is a session key of a previous login.
+If -p is omitted, the password is read from stdin (--password-stdin), the KAJI_PASSWORD environment variable,
+or prompted for without echoing it.
+
+The session key is never printed, unless --show-token is set.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 
+		if password != "" && passwordStdin {
+			return errors.New("--password and --password-stdin are mutually exclusive")
+		}
+
+		// Load the session key of a previous login, unless logging in as a specific user
+		if username == "" {
+			if err := loadSessionKey(); err != nil {
+				fmt.Println(fmt.Sprintf("Unable to load stored session key, trying with username / password. error: %v", err))
+			}
+		}
+
 		// Init Client
-		client := query.GetKajiwotoClient(endpoint)
+		client, err := newKajiwotoClient(endpoint)
+		if err != nil {
+			return err
+		}
 
 		// Check whether there is a Session key defined
 		loginResult := query.LoginResult{}
 		var errLogin error
-		if sessionKey != "" {
-			fmt.Println(fmt.Sprintf("Performing login via Session key: %v", sessionKey))
-			loginResult, errLogin = client.DoLoginAuthToken(sessionKey)
+		if sessionKey != "" && username == "" {
+			fmt.Println("Performing login via Session key")
+			loginResult, errLogin = client.DoLoginAuthToken(cmd.Context(), sessionKey)
 			if errLogin != nil {
 				fmt.Println(fmt.Sprintf("Unable to login via auth token, trying with username / password. error: %v", errLogin))
-				loginResult, errLogin = client.DoLoginUserPW(username, password)
+				loginResult, errLogin = loginWithPassword(cmd, client)
 			} else if loginResult.Login.AuthToken == "" {
 				fmt.Println(fmt.Sprintf("No User information returned from server. Session may be outdated. Trying with username / password."))
-				loginResult, errLogin = client.DoLoginUserPW(username, password)
+				loginResult, errLogin = loginWithPassword(cmd, client)
 			}
 		} else {
 			fmt.Println("Performing login via Username / Password combo")
-			loginResult, errLogin = client.DoLoginUserPW(username, password)
+			loginResult, errLogin = loginWithPassword(cmd, client)
 		}
 
 		// Check for error
@@ -73,23 +108,89 @@ var loginCmd = &cobra.Command{
 		userInfo := &loginResult.Login.User
 		fmt.Println(fmt.Sprintf("Login successful! Hello %v!", userInfo.DisplayName))
 
-		// Update Auth token in config file
+		// Store Auth token and reference it in the profile
 		sessionKey = loginResult.Login.AuthToken
-		viper.Set("sessionkey", loginResult.Login.AuthToken)
-		fmt.Println(fmt.Sprintf("Session key: %v", sessionKey))
-		if err := viper.WriteConfig(); err != nil {
+		current, err := storeSessionKey(string(userInfo.Username), sessionKey)
+		if err != nil {
 			return err
 		}
+		fmt.Println(fmt.Sprintf("Session key of profile %v stored in credential store: %v", current.Name, current.Credentials))
+		if showToken {
+			fmt.Println(fmt.Sprintf("Session key: %v", sessionKey))
+		}
 
 		return nil
 	},
 }
 
+// loginWithPassword performs login via user / pw combination. Credentials missing on the command line are taken from
+// stdin, the environment, the current profile or prompted for.
+func loginWithPassword(cmd *cobra.Command, client query.KajiwotoClient) (query.LoginResult, error) {
+	loginUsername, loginPassword, err := resolveCredentials(cmd.InOrStdin())
+	if err != nil {
+		return query.LoginResult{}, err
+	}
+	return client.DoLoginUserPW(cmd.Context(), loginUsername, loginPassword)
+}
+
+// resolveCredentials returns the username and password used for login. The password is taken from (in order)
+// --password, --password-stdin, the environment, or a hidden prompt if running in a terminal.
+func resolveCredentials(stdin io.Reader) (string, string, error) {
+	loginUsername := username
+	if loginUsername == "" {
+		current, _ := loadProfile(currentProfile())
+		loginUsername = current.Username
+	}
+	if loginUsername == "" {
+		var err error
+		if loginUsername, err = readUsername(); errors.Is(err, errNoTerminal) {
+			return "", "", errors.New("no username provided: use -u")
+		} else if err != nil {
+			return "", "", err
+		}
+	}
+
+	if password != "" {
+		return loginUsername, password, nil
+	}
+	if passwordStdin {
+		content, err := ioutil.ReadAll(stdin)
+		if err != nil {
+			return "", "", err
+		}
+		loginPassword := strings.TrimRight(string(content), "\r\n")
+		if loginPassword == "" {
+			return "", "", errors.New("empty password provided via stdin")
+		}
+		return loginUsername, loginPassword, nil
+	}
+	if loginPassword, ok := os.LookupEnv(passwordEnv); ok && loginPassword != "" {
+		return loginUsername, loginPassword, nil
+	}
+	loginPassword, err := readPassword(loginUsername)
+	if errors.Is(err, errNoTerminal) {
+		return "", "", fmt.Errorf("no password provided: use --password-stdin or set %v", passwordEnv)
+	}
+	return loginUsername, string(loginPassword), err
+}
+
+// readUsername prompts for the username if running in a terminal
+var readUsername = func() (string, error) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return "", errNoTerminal
+	}
+	fmt.Fprint(os.Stderr, "Username: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.TrimSpace(line), err
+}
+
 func init() {
 	rootCmd.AddCommand(loginCmd)
 
 	// Flags for Login
 	loginCmd.PersistentFlags().StringVarP(&username, "username", "u", "", "username, required for first login or if switching accounts.")
-	loginCmd.PersistentFlags().StringVarP(&password, "password", "p", "", "password, required for first login or if switching accounts.")
+	loginCmd.PersistentFlags().StringVarP(&password, "password", "p", "", "password; prompted for if not set. Prefer --password-stdin or "+passwordEnv+", since this is visible in your shell history.")
+	loginCmd.PersistentFlags().BoolVar(&passwordStdin, "password-stdin", false, "read the password from stdin")
+	loginCmd.PersistentFlags().BoolVar(&showToken, "show-token", false, "print the session key after successful login")
 
 }
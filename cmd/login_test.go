@@ -1 +1,211 @@
 package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLoginUserPW(t *testing.T) {
+	env := newTestEnvironment(t)
+	env.sessionKey = ""
+
+	if err := env.execute("login", "-u", testUsername, "-p", testPassword); err != nil {
+		t.Fatal(err)
+	}
+
+	// The config file only references the session key, which is stored encrypted
+	config, err := ioutil.ReadFile(env.configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(config), "credentials: file:"+defaultProfile) {
+		t.Errorf("credentials reference not stored in config:\n%v", string(config))
+	}
+	if strings.Contains(string(config), "session-") {
+		t.Errorf("session key stored in config:\n%v", string(config))
+	}
+	info, err := os.Stat(env.path("credentials"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected credentials file mode 0600, got %v", info.Mode().Perm())
+	}
+	credentials, err := ioutil.ReadFile(env.path("credentials"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(credentials), "session-") {
+		t.Error("session key stored in plaintext")
+	}
+
+	// Further commands use the stored session key
+	env.addDataset("ds1", testUserID, 0, newTestEntry("", "Hello", "Hi"))
+	if err = env.execute("dataset", "download", "-s", "ds1", "-t", env.path("dataset.csv")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoginMigratesPlaintextSessionKey(t *testing.T) {
+	env := newTestEnvironment(t)
+	if err := ioutil.WriteFile(env.configFile, []byte("sessionkey: "+env.sessionKey+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	env.sessionKey = ""
+
+	if err := env.execute("login"); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := ioutil.ReadFile(env.configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(config), "session-") || !strings.Contains(string(config), "credentials: file:"+defaultProfile) {
+		t.Errorf("session key not moved into credential store:\n%v", string(config))
+	}
+}
+
+func TestLegacySessionKeyMigratedOnUse(t *testing.T) {
+	env := newTestEnvironment(t)
+	env.addDataset("ds1", testUserID, 0)
+	if err := ioutil.WriteFile(env.configFile, []byte("sessionkey: "+env.sessionKey+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	env.sessionKey = ""
+
+	// The session key is moved into the credential store once, and taken from there afterwards
+	for i := 0; i < 2; i++ {
+		if err := env.execute("dataset", "info", "-s", "ds1"); err != nil {
+			t.Fatal(err)
+		}
+		config, err := ioutil.ReadFile(env.configFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(config), "sessionkey") || !strings.Contains(string(config), "credentials: file:"+defaultProfile) {
+			t.Errorf("session key not moved into credential store:\n%v", string(config))
+		}
+	}
+	if _, err := os.Stat(env.path("credentials")); err != nil {
+		t.Errorf("expected credentials file: %v", err)
+	}
+}
+
+func TestLoginWrongPassphrase(t *testing.T) {
+	env := newTestEnvironment(t)
+	env.sessionKey = ""
+	if err := env.execute("login", "-u", testUsername, "-p", testPassword); err != nil {
+		t.Fatal(err)
+	}
+
+	readPassphrase = func() ([]byte, error) {
+		return []byte("wrong"), nil
+	}
+	env.addDataset("ds1", testUserID, 0)
+	if err := env.execute("dataset", "info", "-s", "ds1"); err == nil {
+		t.Error("expected error using a wrong passphrase")
+	}
+}
+
+func TestLoginExpiredSessionFallsBackToUserPW(t *testing.T) {
+	env := newTestEnvironment(t)
+	env.client.ExpireSessions()
+
+	if err := env.execute("login", "-u", testUsername, "-p", testPassword); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoginInvalidCredentials(t *testing.T) {
+	env := newTestEnvironment(t)
+	env.sessionKey = ""
+
+	if err := env.execute("login", "-u", testUsername, "-p", "wrong"); err == nil {
+		t.Fatal("expected login to fail")
+	}
+}
+
+func TestLoginPasswordSources(t *testing.T) {
+	env := newTestEnvironment(t)
+	env.sessionKey = ""
+
+	// Password via stdin
+	rootCmd.SetIn(strings.NewReader(testPassword + "\n"))
+	defer rootCmd.SetIn(nil)
+	if err := env.execute("login", "-u", testUsername, "--password-stdin"); err != nil {
+		t.Fatal(err)
+	}
+	env.client.ExpireSessions()
+	rootCmd.SetIn(strings.NewReader("wrong\n"))
+	if err := env.execute("login", "-u", testUsername, "--password-stdin"); err == nil {
+		t.Error("expected login with wrong password from stdin to fail")
+	}
+	if err := env.execute("login", "-u", testUsername, "-p", testPassword, "--password-stdin"); err == nil {
+		t.Error("expected error combining --password and --password-stdin")
+	}
+
+	// Password from the environment
+	previous, hadPrevious := os.LookupEnv(passwordEnv)
+	if err := os.Setenv(passwordEnv, testPassword); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if hadPrevious {
+			_ = os.Setenv(passwordEnv, previous)
+		} else {
+			_ = os.Unsetenv(passwordEnv)
+		}
+	}()
+	readPassword = func(username string) ([]byte, error) {
+		t.Error("unexpected password prompt")
+		return nil, errNoTerminal
+	}
+	env.client.ExpireSessions()
+	if err := env.execute("login", "-u", testUsername); err != nil {
+		t.Fatal(err)
+	}
+	_ = os.Unsetenv(passwordEnv)
+
+	// Prompt, using the username of the profile
+	prompted := ""
+	readPassword = func(username string) ([]byte, error) {
+		prompted = username
+		return []byte(testPassword), nil
+	}
+	env.client.ExpireSessions()
+	if err := env.execute("login"); err != nil {
+		t.Fatal(err)
+	}
+	if prompted != testUsername {
+		t.Errorf("expected password prompt for %v, got %q", testUsername, prompted)
+	}
+
+	// Without a terminal, there is no way to get the password
+	readPassword = func(username string) ([]byte, error) {
+		return nil, errNoTerminal
+	}
+	env.client.ExpireSessions()
+	if err := env.execute("login"); err == nil || !strings.Contains(err.Error(), passwordEnv) {
+		t.Errorf("expected error pointing to %v, got %v", passwordEnv, err)
+	}
+}
+
+func TestLoginIgnoresPasswordInConfig(t *testing.T) {
+	env := newTestEnvironment(t)
+	env.sessionKey = ""
+	t.Setenv(passwordEnv, "")
+	if err := ioutil.WriteFile(env.configFile, []byte("password: "+testPassword+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The password must only be taken from the environment, never from the config file
+	readPassword = func(username string) ([]byte, error) {
+		return nil, errNoTerminal
+	}
+	if err := env.execute("login", "-u", testUsername); err == nil || !strings.Contains(err.Error(), passwordEnv) {
+		t.Errorf("expected login to fail without a password, got %v", err)
+	}
+}
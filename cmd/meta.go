@@ -0,0 +1,165 @@
+// Package cmd
+/*
+Copyright © 2021 NAME HERE runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+
+	"github.com/runtimeracer/kajitool/query"
+	"github.com/runtimeracer/kajitool/util"
+)
+
+// datasetMetaSuffix is appended to the target file to build the path of its companion metadata file
+const datasetMetaSuffix = ".meta.json"
+
+// Flags
+var writeMeta bool
+
+// datasetMeta describes all metadata of a dataset, including its documents, in a readable form
+type datasetMeta struct {
+	ID              string           `json:"id"`
+	Name            string           `json:"name"`
+	Description     string           `json:"description"`
+	Count           int              `json:"count"`
+	Deleted         bool             `json:"deleted"`
+	NSFW            bool             `json:"nsfw"`
+	Price           int              `json:"price"`
+	Purchased       bool             `json:"purchased"`
+	Status          string           `json:"status"`
+	Tags            []string         `json:"tags"`
+	Personalities   [][]string       `json:"personalities"`
+	PetSpeciesIDs   []string         `json:"petSpeciesIds"`
+	DominantColors  []string         `json:"dominantColors"`
+	ProfilePhotoURI string           `json:"profilePhotoUri"`
+	Kudos           datasetMetaKudos `json:"kudos"`
+	Owner           datasetMetaOwner `json:"owner"`
+	UpdatedAt       uint64           `json:"updatedAt"`
+	Documents       []datasetMetaDoc `json:"documents"`
+}
+
+type datasetMetaKudos struct {
+	ID       string `json:"id"`
+	Upvoted  bool   `json:"upvoted"`
+	Upvotes  int    `json:"upvotes"`
+	Comments int    `json:"comments"`
+}
+
+type datasetMetaOwner struct {
+	ID          string `json:"id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"displayName"`
+}
+
+type datasetMetaDoc struct {
+	ID          string `json:"id"`
+	Order       int    `json:"order"`
+	Title       string `json:"title"`
+	Content     string `json:"content"`
+	QueueStatus string `json:"queueStatus"`
+	QueuedAt    uint64 `json:"queuedAt"`
+	BuiltAt     uint64 `json:"builtAt"`
+	CreatedAt   uint64 `json:"createdAt"`
+	UpdatedAt   uint64 `json:"updatedAt"`
+}
+
+// newDatasetMeta converts the GraphQL dataset info into its readable metadata
+func newDatasetMeta(datasetInfo query.AITrainerGroup) datasetMeta {
+	meta := datasetMeta{
+		ID:              string(datasetInfo.ID),
+		Name:            string(datasetInfo.Name),
+		Description:     string(datasetInfo.Description),
+		Count:           int(datasetInfo.Count),
+		Deleted:         bool(datasetInfo.Deleted),
+		NSFW:            bool(datasetInfo.NSFW),
+		Price:           int(datasetInfo.Price),
+		Purchased:       bool(datasetInfo.Purchased),
+		Status:          string(datasetInfo.Status),
+		Tags:            make([]string, len(datasetInfo.Tags)),
+		Personalities:   make([][]string, len(datasetInfo.Personalities)),
+		PetSpeciesIDs:   make([]string, len(datasetInfo.PetSpeciesIds)),
+		DominantColors:  make([]string, len(datasetInfo.DominantColors)),
+		ProfilePhotoURI: string(datasetInfo.ProfilePhotoUri),
+		Kudos: datasetMetaKudos{
+			ID:       string(datasetInfo.Kudos.ID),
+			Upvoted:  bool(datasetInfo.Kudos.Upvoted),
+			Upvotes:  int(datasetInfo.Kudos.Upvotes),
+			Comments: int(datasetInfo.Kudos.Comments),
+		},
+		Owner: datasetMetaOwner{
+			ID:          string(datasetInfo.User.ID),
+			Username:    string(datasetInfo.User.Username),
+			DisplayName: string(datasetInfo.User.DisplayName),
+		},
+		UpdatedAt: datasetInfo.UpdatedAt,
+		Documents: make([]datasetMetaDoc, len(datasetInfo.Documents)),
+	}
+	for i, tag := range datasetInfo.Tags {
+		meta.Tags[i] = string(tag)
+	}
+	for i, personality := range datasetInfo.Personalities {
+		meta.Personalities[i] = make([]string, len(personality))
+		for j, trait := range personality {
+			meta.Personalities[i][j] = string(trait)
+		}
+	}
+	for i, species := range datasetInfo.PetSpeciesIds {
+		meta.PetSpeciesIDs[i] = string(species)
+	}
+	for i, color := range datasetInfo.DominantColors {
+		meta.DominantColors[i] = string(color)
+	}
+	for i, document := range datasetInfo.Documents {
+		meta.Documents[i] = datasetMetaDoc{
+			ID:          string(document.ID),
+			Order:       int(document.Order),
+			Title:       string(document.Title),
+			Content:     string(document.Content),
+			QueueStatus: string(document.QueueStatus),
+			QueuedAt:    document.QueuedAt,
+			BuiltAt:     document.BuiltAt,
+			CreatedAt:   document.CreatedAt,
+			UpdatedAt:   document.UpdatedAt,
+		}
+	}
+	return meta
+}
+
+// datasetMetaPath returns the path of the companion metadata file of a dataset file
+func datasetMetaPath(target string) string {
+	return target + datasetMetaSuffix
+}
+
+// writeDatasetMeta writes the metadata of the dataset into the companion file of the target
+func writeDatasetMeta(target string, datasetInfo query.AITrainerGroup) error {
+	return util.WriteFileAtomic(datasetMetaPath(target), func(w io.Writer) error {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(newDatasetMeta(datasetInfo))
+	})
+}
+
+// readDatasetMeta reads the companion metadata file of the target
+func readDatasetMeta(target string) (meta datasetMeta, err error) {
+	content, err := ioutil.ReadFile(datasetMetaPath(target))
+	if err != nil {
+		return meta, err
+	}
+	err = json.Unmarshal(content, &meta)
+	return meta, err
+}
@@ -0,0 +1,317 @@
+// Package cmd
+/*
+Copyright © 2021 NAME HERE runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/runtimeracer/kajitool/credentials"
+	"github.com/runtimeracer/kajitool/util"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	defaultProfile = "default"
+	// profileConfigKey holds the name of the profile used if --profile is not set
+	profileConfigKey = "profile"
+	// profilesConfigKey holds a map of all profiles by name
+	profilesConfigKey = "profiles"
+)
+
+// Flags
+var profileName string
+
+// validProfileName matches names usable as config keys. Viper treats keys case-insensitively.
+var validProfileName = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// profile is an account used with kajitool. Its session key is stored in a credential store, referenced by Credentials.
+type profile struct {
+	Name        string
+	Username    string
+	Endpoint    string
+	Credentials string
+}
+
+// profileCmd represents the profile command
+var profileCmd = &cobra.Command{
+	Use:   "profile",
+	Short: "Manage the profiles of your kajiwoto accounts",
+	Long: `profile is used to manage multiple kajiwoto accounts. Each profile stores the session key and endpoint of one account.
+Profiles are created by logging in using --profile, and selected for a single command using --profile as well.
+Offers various subcommands to list, select and remove profiles.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		_ = cmd.Help()
+	},
+}
+
+// profileListCmd represents the profile list command
+var profileListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists all profiles. The current profile is marked with '*'.",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		profiles := listProfiles()
+		if len(profiles) == 0 {
+			fmt.Println("No profiles found. Use 'kajitool login --profile NAME' to create one.")
+			return nil
+		}
+		return printProfiles(os.Stdout, profiles, currentProfile())
+	},
+}
+
+// profileUseCmd represents the profile use command
+var profileUseCmd = &cobra.Command{
+	Use:   "use NAME",
+	Short: "Selects the profile used by all further commands, unless --profile is set.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name, err := validateProfileName(args[0])
+		if err != nil {
+			return err
+		}
+		if _, ok := loadProfile(name); !ok {
+			return fmt.Errorf("profile %q not found", name)
+		}
+
+		if err = updateConfig(func(config map[string]interface{}) error {
+			config[profileConfigKey] = name
+			return nil
+		}); err != nil {
+			return err
+		}
+		fmt.Println(fmt.Sprintf("Using profile: %v", name))
+		return nil
+	},
+}
+
+// profileRemoveCmd represents the profile remove command
+var profileRemoveCmd = &cobra.Command{
+	Use:   "remove NAME",
+	Short: "Removes a profile along with its stored session key.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name, err := validateProfileName(args[0])
+		if err != nil {
+			return err
+		}
+		removed, ok := loadProfile(name)
+		if !ok {
+			return fmt.Errorf("profile %q not found", name)
+		}
+
+		// Remove the session key first, so it isn't left behind without a reference
+		if reference, errReference := credentials.ParseReference(removed.Credentials); errReference == nil {
+			store, errStore := openCredentialStore(reference.Backend)
+			if errStore != nil {
+				return errStore
+			}
+			if err = store.Delete(reference.Account); err != nil {
+				return err
+			}
+		}
+
+		if err = updateConfig(func(config map[string]interface{}) error {
+			if profiles, okProfiles := config[profilesConfigKey].(map[string]interface{}); okProfiles {
+				delete(profiles, name)
+			}
+			if name == defaultProfile {
+				removeLegacyCredentials(config)
+			}
+			if selected, _ := config[profileConfigKey].(string); selected == name {
+				delete(config, profileConfigKey)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		fmt.Println(fmt.Sprintf("Removed profile: %v", name))
+		return nil
+	},
+}
+
+// printProfiles prints a table of all profiles
+func printProfiles(w io.Writer, profiles []profile, current string) error {
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "\tPROFILE\tUSERNAME\tENDPOINT\tCREDENTIALS")
+	for _, p := range profiles {
+		marker := ""
+		if p.Name == current {
+			marker = "*"
+		}
+		fmt.Fprintf(table, "%v\t%v\t%v\t%v\t%v\n", marker, p.Name, p.Username, p.Endpoint, p.Credentials)
+	}
+	return table.Flush()
+}
+
+// currentProfile returns the name of the profile selected via --profile, the config file or the environment
+func currentProfile() string {
+	if profileName == "" {
+		return defaultProfile
+	}
+	return strings.ToLower(profileName)
+}
+
+// loadProfile reads the profile from the config. For the default profile, the credentials reference of older
+// versions is used if there is no profile entry yet.
+func loadProfile(name string) (profile, bool) {
+	key := profilesConfigKey + "." + name
+	if viper.IsSet(key) {
+		return profile{
+			Name:        name,
+			Username:    viper.GetString(key + ".username"),
+			Endpoint:    viper.GetString(key + ".endpoint"),
+			Credentials: viper.GetString(key + "." + credentialsConfigKey),
+		}, true
+	}
+	if name == defaultProfile && viper.GetString(credentialsConfigKey) != "" {
+		return profile{Name: name, Credentials: viper.GetString(credentialsConfigKey)}, true
+	}
+	return profile{Name: name}, false
+}
+
+// listProfiles returns all profiles ordered by name
+func listProfiles() []profile {
+	names := make([]string, 0)
+	for name := range viper.GetStringMap(profilesConfigKey) {
+		names = append(names, name)
+	}
+	if _, ok := viper.GetStringMap(profilesConfigKey)[defaultProfile]; !ok && viper.GetString(credentialsConfigKey) != "" {
+		names = append(names, defaultProfile)
+	}
+	sort.Strings(names)
+
+	profiles := make([]profile, 0, len(names))
+	for _, name := range names {
+		if p, ok := loadProfile(name); ok {
+			profiles = append(profiles, p)
+		}
+	}
+	return profiles
+}
+
+// saveProfile creates or replaces the profile in the config file
+func saveProfile(p profile) error {
+	return updateConfig(func(config map[string]interface{}) error {
+		profiles, ok := config[profilesConfigKey].(map[string]interface{})
+		if !ok {
+			profiles = make(map[string]interface{})
+			config[profilesConfigKey] = profiles
+		}
+		profiles[p.Name] = map[string]interface{}{
+			"username":           p.Username,
+			"endpoint":           p.Endpoint,
+			credentialsConfigKey: p.Credentials,
+		}
+		if p.Name == defaultProfile {
+			removeLegacyCredentials(config)
+		}
+		return nil
+	})
+}
+
+// removeLegacyCredentials removes session keys and references stored outside of profiles by older versions
+func removeLegacyCredentials(config map[string]interface{}) {
+	delete(config, credentialsConfigKey)
+	delete(config, legacySessionKeyConfigKey)
+}
+
+// applyProfile uses the endpoint of the current profile, unless set explicitly
+func applyProfile(endpointSet bool) {
+	if p, ok := loadProfile(currentProfile()); ok && p.Endpoint != "" && !endpointSet {
+		endpoint = p.Endpoint
+	}
+}
+
+// updateConfig modifies the content of the config file and reloads it.
+// Unlike viper.WriteConfig, this allows removing keys and doesn't write settings taken from flags or the environment.
+func updateConfig(update func(config map[string]interface{}) error) error {
+	path := viper.ConfigFileUsed()
+	if path == "" {
+		return errors.New("no config file in use")
+	}
+
+	config, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+	if err = update(config); err != nil {
+		return err
+	}
+
+	var output []byte
+	if output, err = yaml.Marshal(config); err != nil {
+		return err
+	}
+	if err = util.WriteFileAtomic(path, func(w io.Writer) error {
+		_, errWrite := w.Write(output)
+		return errWrite
+	}); err != nil {
+		return err
+	}
+	return viper.ReadInConfig()
+}
+
+// readConfigFile reads the content of the config file, without settings taken from flags or the environment
+func readConfigFile(path string) (map[string]interface{}, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	raw := make(map[interface{}]interface{})
+	if err = yaml.Unmarshal(content, &raw); err != nil {
+		return nil, fmt.Errorf("unable to read config file %v: %w", path, err)
+	}
+	return normalizeConfigMap(raw), nil
+}
+
+// normalizeConfigMap converts the nested maps decoded by yaml.v2 into maps with string keys
+func normalizeConfigMap(raw map[interface{}]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(raw))
+	for key, value := range raw {
+		if nested, ok := value.(map[interface{}]interface{}); ok {
+			result[fmt.Sprintf("%v", key)] = normalizeConfigMap(nested)
+		} else {
+			result[fmt.Sprintf("%v", key)] = value
+		}
+	}
+	return result
+}
+
+func init() {
+	rootCmd.AddCommand(profileCmd)
+	profileCmd.AddCommand(profileListCmd)
+	profileCmd.AddCommand(profileUseCmd)
+	profileCmd.AddCommand(profileRemoveCmd)
+}
+
+func validateProfileName(name string) (string, error) {
+	name = strings.ToLower(name)
+	if !validProfileName.MatchString(name) {
+		return "", fmt.Errorf("invalid profile name %q, only letters, digits, '-' and '_' are allowed", name)
+	}
+	return name, nil
+}
@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/runtimeracer/kajitool/query"
+)
+
+func TestProfiles(t *testing.T) {
+	env := newTestEnvironment(t)
+	teamKey := env.client.AddUser(query.User{ID: "user-2", Username: "team", DisplayName: "Team"}, "team-secret")
+	if _, err := env.client.DoLoginAuthToken(context.Background(), teamKey); err != nil {
+		t.Fatal(err)
+	}
+	env.addDataset("ds1", testUserID, 0)
+	env.addDataset("ds2", "user-2", 0)
+	env.sessionKey = ""
+
+	if err := env.execute("login", "-u", testUsername, "-p", testPassword); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.execute("--profile", "team", "login", "-u", "team", "-p", "team-secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each profile uses its own session key
+	if err := env.execute("dataset", "download", "-s", "ds1", "-t", env.path("ds1.csv")); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.execute("--profile", "team", "dataset", "download", "-s", "ds2", "-t", env.path("ds2.csv")); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.execute("dataset", "download", "-s", "ds2", "-t", env.path("ds2.csv")); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.execute("--profile", "unknown", "dataset", "info", "-s", "ds1"); err == nil {
+		t.Error("expected error using an unknown profile")
+	}
+
+	var output bytes.Buffer
+	if err := printProfiles(&output, listProfiles(), defaultProfile); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output.String(), "*  default") || !strings.Contains(output.String(), "team") {
+		t.Errorf("unexpected profile list:\n%v", output.String())
+	}
+
+	// Select the team profile for all further commands
+	if err := env.execute("profile", "use", "team"); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.execute("profile", "use", "unknown"); err == nil {
+		t.Error("expected error selecting an unknown profile")
+	}
+	if err := env.execute("profile", "list"); err != nil {
+		t.Fatal(err)
+	}
+	if currentProfile() != "team" {
+		t.Errorf("expected current profile 'team', got %q", currentProfile())
+	}
+
+	// Removing the profile removes its session key and selection
+	if err := env.execute("profile", "remove", "team"); err != nil {
+		t.Fatal(err)
+	}
+	config, err := ioutil.ReadFile(env.configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(config), "team") {
+		t.Errorf("profile not removed from config:\n%v", string(config))
+	}
+	if err = env.execute("--profile", "team", "dataset", "info", "-s", "ds2"); err == nil {
+		t.Error("expected error using a removed profile")
+	}
+	if err = env.execute("dataset", "info", "-s", "ds1"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProfileEndpoint(t *testing.T) {
+	env := newTestEnvironment(t)
+	env.addDataset("ds1", testUserID, 0)
+	env.sessionKey = ""
+
+	const devEndpoint = "http://localhost:8080/graphql"
+	if err := env.execute("--profile", "dev", "--endpoint", devEndpoint, "login", "-u", testUsername, "-p", testPassword); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.execute("--profile", "dev", "dataset", "info", "-s", "ds1"); err != nil {
+		t.Fatal(err)
+	}
+	if endpoint != devEndpoint {
+		t.Errorf("expected endpoint of profile %q, got %q", devEndpoint, endpoint)
+	}
+	if err := env.execute("--profile", "dev", "--endpoint", "http://other/graphql", "dataset", "info", "-s", "ds1"); err != nil {
+		t.Fatal(err)
+	}
+	if endpoint != "http://other/graphql" {
+		t.Errorf("expected endpoint set via flag, got %q", endpoint)
+	}
+}
+
+func TestProfileIgnoresLegacySessionKey(t *testing.T) {
+	env := newTestEnvironment(t)
+	teamKey := env.client.AddUser(query.User{ID: "user-2", Username: "team", DisplayName: "Team"}, "team-secret")
+	if _, err := env.client.DoLoginAuthToken(context.Background(), teamKey); err != nil {
+		t.Fatal(err)
+	}
+	env.addDataset("ds1", testUserID, 0)
+	env.addDataset("ds2", "user-2", 0)
+	if err := ioutil.WriteFile(env.configFile, []byte("sessionkey: "+env.sessionKey+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	env.sessionKey = ""
+
+	// The session key of older versions belongs to the default profile only
+	if err := env.execute("--profile", "team", "dataset", "info", "-s", "ds2"); err == nil {
+		t.Error("expected error using a profile without login")
+	}
+	if err := env.execute("--profile", "team", "login", "-u", "team", "-p", "team-secret"); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.execute("--profile", "team", "dataset", "backup", "-t", env.path("team"), "--dataset", "ds2"); err != nil {
+		t.Fatalf("expected the session key of the team profile to be used: %v", err)
+	}
+	if err := env.execute("dataset", "backup", "-t", env.path("default"), "--dataset", "ds1"); err != nil {
+		t.Fatalf("expected the session key of the default profile to be used: %v", err)
+	}
+
+	// Logging in as a specific user doesn't reuse the stored session key
+	if err := env.execute("login", "-u", "team", "-p", "team-secret"); err != nil {
+		t.Fatal(err)
+	}
+	if current, _ := loadProfile(defaultProfile); current.Username != "team" {
+		t.Errorf("expected default profile of user team, got %+v", current)
+	}
+}
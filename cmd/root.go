@@ -22,12 +22,18 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"github.com/runtimeracer/kajitool/constants"
+	"github.com/runtimeracer/kajitool/credentials"
+	"github.com/runtimeracer/kajitool/query"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/spf13/viper"
@@ -35,6 +41,24 @@ import (
 
 var cfgFile string
 var sessionKey, endpoint string
+var rateLimit float64
+var maxRetries int
+var requestTimeout time.Duration
+var proxy, caBundle string
+
+// newKajiwotoClient creates the API client used by all commands. Can be replaced for testing.
+var newKajiwotoClient = func(endpoint string) (query.KajiwotoClient, error) {
+	options := query.DefaultClientOptions()
+	options.Timeout = requestTimeout
+	options.Proxy = proxy
+	options.CABundle = caBundle
+	options.RequestsPerSecond = rateLimit
+	options.MaxRetries = maxRetries
+	options.OnRetry = func(attempt int, delay time.Duration, err error) {
+		fmt.Println(fmt.Sprintf("WARNING: Request failed, retry %v/%v in %v. error: %v", attempt, maxRetries, delay.Round(time.Millisecond), err))
+	}
+	return query.GetKajiwotoClientWithOptions(endpoint, options)
+}
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
@@ -54,12 +78,30 @@ to quickly create a Cobra application.`,
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go handleInterrupts(cancel)
+
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
 		//fmt.Println(err)
 		os.Exit(1)
 	}
 }
 
+// handleInterrupts cancels the context of the running command on SIGINT / SIGTERM, so it stops after the current request.
+// A second signal terminates kajitool immediately.
+func handleInterrupts(cancel context.CancelFunc) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	<-signals
+	fmt.Println("Interrupted. Stopping after the current request, press Ctrl-C again to abort immediately.")
+	cancel()
+
+	<-signals
+	os.Exit(130)
+}
+
 func init() {
 	cobra.OnInitialize(initConfig)
 
@@ -67,6 +109,14 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.kajitool.yaml)")
 	rootCmd.PersistentFlags().StringVarP(&endpoint, "endpoint", "e", constants.DefaultEndpoint, "Specify target Endpoint for API Requests")
 	rootCmd.PersistentFlags().StringVar(&sessionKey, "sessionkey", "", "manually specify a session key if required")
+	rootCmd.PersistentFlags().StringVar(&profileName, "profile", "", "profile of the account to use (default is the profile selected via 'profile use', or '"+defaultProfile+"')")
+	rootCmd.PersistentFlags().Float64Var(&rateLimit, "rate-limit", query.DefaultRequestsPerSecond, "maximum amount of API requests per second (0 = unlimited)")
+	rootCmd.PersistentFlags().IntVar(&maxRetries, "max-retries", query.DefaultMaxRetries, "amount of retries for API requests failing with a transient error")
+	rootCmd.PersistentFlags().DurationVar(&requestTimeout, "timeout", query.DefaultRequestTimeout, "timeout of a single API request (0 = no timeout)")
+	rootCmd.PersistentFlags().StringVar(&proxy, "proxy", "", "URL of the HTTP(S) proxy used for API requests (default taken from HTTPS_PROXY / HTTP_PROXY)")
+	rootCmd.PersistentFlags().StringVar(&caBundle, "ca-bundle", "", "PEM file with additional CA certificates to trust, e.g. of a corporate proxy")
+	rootCmd.PersistentFlags().StringVar(&credentialStore, "credential-store", credentials.BackendFile, "where to store the session key after login (file, keyring)")
+	rootCmd.PersistentFlags().StringVar(&credentialsFile, "credentials-file", "", "encrypted file used by the file credential store (default is $HOME/"+credentialsFileName+")")
 
 }
 
@@ -79,7 +129,7 @@ func initConfig() {
 		// Find home directory.
 		home, err := homedir.Dir()
 		if err != nil {
-			fmt.Println(err)
+			fmt.Fprintln(os.Stderr, err)
 			os.Exit(1)
 		}
 
@@ -90,21 +140,23 @@ func initConfig() {
 
 		// Create config file if not exists
 		if err = viper.SafeWriteConfig(); err != nil {
-			fmt.Println(err)
+			fmt.Fprintln(os.Stderr, err)
 		}
 	}
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
-		fmt.Println("Using config file:", viper.ConfigFileUsed())
+		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
 	} else {
-		fmt.Println(err)
+		fmt.Fprintln(os.Stderr, err)
 	}
 
 	viper.SetEnvPrefix(constants.EnvPrefix)
 	viper.AutomaticEnv() // read in environment variables that match
 
+	endpointSet := rootCmd.PersistentFlags().Changed("endpoint")
 	bindFlags(rootCmd)
+	applyProfile(endpointSet || viper.IsSet("endpoint"))
 
 }
 
@@ -120,6 +172,12 @@ func bindFlags(cmd *cobra.Command) {
 			}
 		}
 
+		// The session key stored in the config file by older versions is moved into the credential store by
+		// loadSessionKey, instead of overriding the session keys of all profiles
+		if f.Name == legacySessionKeyConfigKey && viper.InConfig(f.Name) {
+			return
+		}
+
 		// Apply the viper config value to the flag when the flag is not set and viper has a value
 		if !f.Changed && viper.IsSet(f.Name) {
 			val := viper.Get(f.Name)
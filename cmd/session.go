@@ -0,0 +1,90 @@
+// Package cmd
+/*
+Copyright © 2021 NAME HERE runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/runtimeracer/kajitool/credentials"
+	"github.com/runtimeracer/kajitool/query"
+	"golang.org/x/term"
+)
+
+// errNoTerminal is returned by prompts if kajitool doesn't run in a terminal
+var errNoTerminal = errors.New("not running in a terminal")
+
+// readPassword prompts for the password of the user without echoing the input
+var readPassword = func(username string) ([]byte, error) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return nil, errNoTerminal
+	}
+	fmt.Fprintf(os.Stderr, "Password for %v: ", username)
+	password, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	return password, err
+}
+
+// newSessionClient creates the API client for commands working with the session of the current profile.
+// Expired sessions are refreshed by logging in again as the user of the profile.
+func newSessionClient(endpoint string) (query.KajiwotoClient, error) {
+	client, err := newKajiwotoClient(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return query.NewSessionClient(client, sessionKey, reauthenticate, persistSessionKey), nil
+}
+
+// reauthenticate logs in as the user of the current profile, using the password from the environment or prompting for it
+func reauthenticate(ctx context.Context, client query.KajiwotoClient) (query.LoginResult, error) {
+	current, _ := loadProfile(currentProfile())
+	if current.Username == "" {
+		return query.LoginResult{}, query.ErrSessionExpired
+	}
+
+	fmt.Println(fmt.Sprintf("Session of profile %v expired, logging in again as %v.", current.Name, current.Username))
+	if password, ok := os.LookupEnv(passwordEnv); ok && password != "" {
+		return client.DoLoginUserPW(ctx, current.Username, password)
+	}
+	password, err := readPassword(current.Username)
+	if errors.Is(err, errNoTerminal) {
+		return query.LoginResult{}, query.ErrSessionExpired
+	} else if err != nil {
+		return query.LoginResult{}, err
+	}
+	return client.DoLoginUserPW(ctx, current.Username, string(password))
+}
+
+// persistSessionKey replaces the session key of the current profile in its credential store
+func persistSessionKey(authToken string) error {
+	sessionKey = authToken
+	current, ok := loadProfile(currentProfile())
+	if !ok {
+		return nil
+	}
+	reference, err := credentials.ParseReference(current.Credentials)
+	if err != nil {
+		return err
+	}
+	store, err := openCredentialStore(reference.Backend)
+	if err != nil {
+		return err
+	}
+	return store.Set(reference.Account, authToken)
+}
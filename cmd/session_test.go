@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/runtimeracer/kajitool/query"
+)
+
+// expiringClient expires all sessions once the specified page of dataset entries is requested
+type expiringClient struct {
+	*query.FakeKajiwotoClient
+	expireAtPage int
+	expired      bool
+}
+
+func (c *expiringClient) GetAITrainedList(ctx context.Context, aiTrainerGroupID, searchQuery, authToken string, limit, page int) ([]query.AITrained, error) {
+	if page == c.expireAtPage && !c.expired {
+		c.expired = true
+		c.ExpireSessions()
+	}
+	return c.FakeKajiwotoClient.GetAITrainedList(ctx, aiTrainerGroupID, searchQuery, authToken, limit, page)
+}
+
+// useExpiringClient replaces the API client by one expiring all sessions on the specified page of a download
+func (env *testEnvironment) useExpiringClient(expireAtPage int) *expiringClient {
+	client := &expiringClient{FakeKajiwotoClient: env.client, expireAtPage: expireAtPage}
+	previous := newKajiwotoClient
+	newKajiwotoClient = func(endpoint string) (query.KajiwotoClient, error) {
+		return client, nil
+	}
+	env.t.Cleanup(func() {
+		newKajiwotoClient = previous
+	})
+	return client
+}
+
+func TestSessionRefreshDuringDownload(t *testing.T) {
+	env := newTestEnvironment(t)
+	entries := make([]DatasetEntry, 0)
+	for i := 0; i < 250; i++ {
+		entries = append(entries, newTestEntry("", fmt.Sprintf("Question %v", i), fmt.Sprintf("Answer %v", i)))
+	}
+	env.addDataset("ds1", testUserID, 0, entries...)
+	env.sessionKey = ""
+	if err := env.execute("login", "-u", testUsername, "-p", testPassword); err != nil {
+		t.Fatal(err)
+	}
+
+	prompts := 0
+	readPassword = func(username string) ([]byte, error) {
+		prompts++
+		if username != testUsername {
+			t.Errorf("expected prompt for %v, got %v", testUsername, username)
+		}
+		return []byte(testPassword), nil
+	}
+	client := env.useExpiringClient(1)
+	if err := env.execute("dataset", "download", "-s", "ds1", "-t", env.path("dataset.csv")); err != nil {
+		t.Fatal(err)
+	}
+	if !client.expired || prompts != 1 {
+		t.Fatalf("expected a single re-authentication after expiry, got %v", prompts)
+	}
+	result, err := readDataset(env.path("dataset.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != len(entries) {
+		t.Errorf("expected %v entries, got %v", len(entries), len(result))
+	}
+
+	// The new session key has been persisted
+	if err = env.execute("dataset", "info", "-s", "ds1"); err != nil {
+		t.Fatal(err)
+	}
+	if prompts != 1 {
+		t.Errorf("expected persisted session key to be used, got %v prompts", prompts)
+	}
+}
+
+func TestSessionExpiredWithoutCredentials(t *testing.T) {
+	env := newTestEnvironment(t)
+	env.addDataset("ds1", testUserID, 0, newTestEntry("", "Hello", "Hi"))
+	env.sessionKey = ""
+	if err := env.execute("login", "-u", testUsername, "-p", testPassword); err != nil {
+		t.Fatal(err)
+	}
+	env.client.ExpireSessions()
+
+	readPassword = func(username string) ([]byte, error) {
+		return nil, query.ErrSessionExpired
+	}
+	err := env.execute("dataset", "download", "-s", "ds1", "-t", env.path("dataset.csv"))
+	if !errors.Is(err, query.ErrSessionExpired) {
+		t.Errorf("expected ErrSessionExpired, got %v", err)
+	}
+}
+
+func TestSessionRefreshDuringUpload(t *testing.T) {
+	env := newTestEnvironment(t)
+	env.addDataset("ds1", testUserID, 0)
+	env.sessionKey = ""
+	if err := env.execute("login", "-u", testUsername, "-p", testPassword); err != nil {
+		t.Fatal(err)
+	}
+	source := env.path("dataset.csv")
+	if err := writeDataset(source, []DatasetEntry{
+		newTestEntry("", "Hello", "Hi"),
+		newTestEntry("", "How are you?", "Fine"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Expire the session of the login, so the upload has to refresh it before training
+	env.client.ExpireSessions()
+	if err := env.execute("dataset", "upload", "-s", source, "-t", "ds1"); err != nil {
+		t.Fatal(err)
+	}
+	if remote := env.client.GetDatasetEntries("ds1"); len(remote) != 2 {
+		t.Errorf("expected 2 trained entries, got %v", len(remote))
+	}
+}
+
+func TestSessionRefreshWithPasswordFromEnvironment(t *testing.T) {
+	env := newTestEnvironment(t)
+	env.addDataset("ds1", testUserID, 0, newTestEntry("", "Hello", "Hi"))
+	env.sessionKey = ""
+	if err := env.execute("login", "-u", testUsername, "-p", testPassword); err != nil {
+		t.Fatal(err)
+	}
+	env.client.ExpireSessions()
+
+	t.Setenv(passwordEnv, testPassword)
+	readPassword = func(username string) ([]byte, error) {
+		t.Error("unexpected password prompt")
+		return nil, errNoTerminal
+	}
+	if err := env.execute("dataset", "download", "-s", "ds1", "-t", env.path("dataset.csv")); err != nil {
+		t.Fatal(err)
+	}
+}
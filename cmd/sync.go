@@ -17,36 +17,599 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
 
+	"github.com/runtimeracer/kajitool/query"
+	"github.com/runtimeracer/kajitool/util"
 	"github.com/spf13/cobra"
 )
 
+const (
+	syncBaseDir = ".kajitool/sync"
+)
+
+// Flags
+var bidirectional bool
+
 // syncCmd represents the sync command
 var syncCmd = &cobra.Command{
 	Use:   "sync",
 	Short: "Syncs (missing) data between two files or datasets",
-	Long: `A longer description that spans multiple lines and likely contains examples
-and usage of using your command. For example:
-
-Cobra is a CLI library for Go that empowers applications.
-This application is a tool to generate the needed files
-to quickly create a Cobra application.`,
-	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("sync called")
+	Long: `sync uploads all entries of the specified source file which are missing in the specified target dataset.
+
+An entry is considered missing if the target dataset has no entry with identical content, regardless of its ID.
+After uploading, the IDs assigned by the API are written back into the source file.
+
+With --bidirectional, sync keeps a snapshot of the last synced state next to the source file (.kajitool/sync/<datasetID>.json)
+and performs a three-way comparison: entries added remotely are pulled into the source file, entries added locally are uploaded,
+and entries changed on both sides are reported as conflicts instead of being overwritten.
+
+Use --dry-run to print the training requests which would be sent, without changing the dataset, the source file or the sync base.
+
+param source: must be a local file. Data will be expected to be in csv, json, jsonl or sqlite format, depending on file extension or --format.
+param target: a full Kajiwoto dataset URL (including ID), or a Kajiwoto dataset ID.`,
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+
+		// Sanity checks
+		if source, err = validateSyncSource(source); err != nil {
+			return err
+		}
+		if target, err = validateSyncTarget(target); err != nil {
+			return err
+		}
+
+		// Read data from source file
+		var localData []DatasetEntry
+		if localData, err = readDataset(source); err != nil {
+			return err
+		}
+
+		// Load session key from the credential store
+		if err = loadSessionKey(); err != nil {
+			return err
+		}
+
+		// Init Client
+		client, err := newSessionClient(endpoint)
+		if err != nil {
+			return err
+		}
+
+		// Login via Session key
+		loginResult := query.LoginResult{}
+		if loginResult, err = client.DoLoginAuthToken(cmd.Context(), sessionKey); err != nil {
+			return err
+		}
+
+		// Get User Info from Login result
+		userInfo := &loginResult.Login.User
+
+		// Get Info on the target Dataset
+		datasetInfo := query.AITrainerGroup{}
+		if datasetInfo, err = client.GetAITrainerGroup(cmd.Context(), target, sessionKey); err != nil {
+			return err
+		}
+
+		// Print some info on the Dataset
+		fmt.Println(fmt.Sprintf("Dataset found: %v", datasetInfo.Name))
+		fmt.Println(fmt.Sprintf("Indexed entries: %v", datasetInfo.Count))
+
+		if datasetInfo.User.ID != userInfo.ID {
+			return errors.New("not your dataset! You cannot upload to foreign datasets")
+		}
+
+		// Fetch current state of the remote dataset
+		var remoteData []DatasetEntry
+		var invalidIDs []string
+		if remoteData, invalidIDs, err = fetchDatasetEntries(cmd.Context(), client, string(datasetInfo.ID)); err != nil {
+			return err
+		}
+		fmt.Println(fmt.Sprintf("Fetched %v remote dataset entries.", len(remoteData)))
+
+		if bidirectional {
+			return syncBidirectional(cmd.Context(), client, string(datasetInfo.ID), localData, remoteData, invalidIDs)
+		}
+
+		// Determine what's missing on the remote side; entries which couldn't be read remotely exist nevertheless
+		assigned := assignRemoteIDs(localData, remoteData)
+		_, checked := splitEntriesByID(localData, invalidIDs)
+		missing := findMissingEntries(checked, remoteData)
+		fmt.Println(fmt.Sprintf("Found %v local entries missing in the remote dataset", len(missing)))
+
+		// Only print the training requests if this is a dry run
+		if dryRun {
+			fmt.Println("Dry run: the following requests would be sent.")
+			if err = trainDatasetEntries(cmd.Context(), client, string(datasetInfo.ID), missing, localData, remoteData); err != nil {
+				return err
+			}
+			fmt.Println(fmt.Sprintf("Dry run: %v entry IDs would be updated in source file %v", assigned, source))
+			return nil
+		}
+
+		// Upload missing entries
+		if err = trainDatasetEntries(cmd.Context(), client, string(datasetInfo.ID), missing, localData, remoteData); err != nil {
+			return err
+		}
+
+		// Fetch remote state again to get the IDs of the uploaded entries
+		if len(missing) > 0 {
+			if remoteData, _, err = fetchDatasetEntries(cmd.Context(), client, string(datasetInfo.ID)); err != nil {
+				return err
+			}
+			assigned += assignRemoteIDs(localData, remoteData)
+		}
+
+		// Write back the assigned IDs
+		if assigned > 0 {
+			fmt.Println(fmt.Sprintf("Updating %v entry IDs in source file %v", assigned, source))
+			if err = writeDataset(source, localData); err != nil {
+				return err
+			}
+		}
+
+		fmt.Println("Sync done.")
+		return nil
 	},
 }
 
 func init() {
 	datasetCmd.AddCommand(syncCmd)
 
-	// Here you will define your flags and configuration settings.
+	// Flags for sync
+	syncCmd.Flags().BoolVar(&bidirectional, "bidirectional", false, "also pull remote changes into the source file, based on the last synced state")
+	syncCmd.Flags().IntVar(&batchSize, "batch-size", 1, "amount of independent entries uploaded within a single request")
+	syncCmd.Flags().BoolVar(&dryRun, "dry-run", false, "print the training requests instead of sending them")
+}
+
+func validateSyncSource(source string) (string, error) {
+	if source == "" {
+		return "", errors.New("empty source")
+	}
+	if isRemoteDataset(source) {
+		return "", errors.New("source must be a local file")
+	}
+
+	return source, nil
+}
+
+func validateSyncTarget(target string) (string, error) {
+	if target == "" {
+		return "", errors.New("empty target")
+	}
+
+	return parseDatasetID(target), nil
+}
+
+// findMissingEntries returns all local entries which have no content-equivalent in the remote entries.
+// Deleted entries and local duplicates are skipped.
+func findMissingEntries(localData, remoteData []DatasetEntry) []DatasetEntry {
+	missing := make([]DatasetEntry, 0)
+	for i := range localData {
+		localEntry := &localData[i]
+		if localEntry.Deleted {
+			continue
+		}
+		if findDuplicateEntry(localEntry, remoteData) >= 0 || findDuplicateEntry(localEntry, missing) >= 0 {
+			continue
+		}
+		missing = append(missing, *localEntry)
+	}
+	return missing
+}
+
+// assignRemoteIDs sets the IDs of local entries to the IDs of their content-equivalent remote entries.
+// Each remote ID is only assigned once. Returns the amount of changed IDs.
+func assignRemoteIDs(localData, remoteData []DatasetEntry) int {
+	// Remote entries already linked to an unchanged local entry can't be claimed again
+	claimed := make(map[string]bool)
+	for i := range localData {
+		if idx := findEntryByID(localData[i].ID, remoteData); idx >= 0 && localData[i].isDuplicate(&remoteData[idx]) {
+			claimed[localData[i].ID] = true
+		}
+	}
+
+	changed := 0
+	for i := range localData {
+		localEntry := &localData[i]
+		if claimed[localEntry.ID] {
+			continue
+		}
+		for j := range remoteData {
+			remoteEntry := &remoteData[j]
+			if claimed[remoteEntry.ID] || !localEntry.isDuplicate(remoteEntry) {
+				continue
+			}
+			localEntry.ID = remoteEntry.ID
+			claimed[remoteEntry.ID] = true
+			changed++
+			break
+		}
+	}
+	return changed
+}
+
+// findDuplicateEntry returns the index of the first entry in store with identical content, or -1
+func findDuplicateEntry(entry *DatasetEntry, store []DatasetEntry) int {
+	for i := range store {
+		if entry.isDuplicate(&store[i]) {
+			return i
+		}
+	}
+	return -1
+}
+
+// findEntryByID returns the index of the first entry in store with the specified ID, or -1
+func findEntryByID(id string, store []DatasetEntry) int {
+	if id == "" {
+		return -1
+	}
+	for i := range store {
+		if store[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// splitEntriesByID separates the entries having one of the specified IDs from the others
+func splitEntriesByID(store []DatasetEntry, ids []string) (matching, others []DatasetEntry) {
+	matching, others = make([]DatasetEntry, 0), make([]DatasetEntry, 0, len(store))
+	for _, entry := range store {
+		if entry.ID != "" && containsString(ids, entry.ID) {
+			matching = append(matching, entry)
+		} else {
+			others = append(others, entry)
+		}
+	}
+	return matching, others
+}
+
+// syncBidirectional performs a three-way sync between the local entries, the remote entries and the last synced state.
+// Local entries whose remote counterpart is listed in invalidIDs are kept unchanged.
+func syncBidirectional(ctx context.Context, client query.KajiwotoClient, datasetID string, localData, remoteData []DatasetEntry, invalidIDs []string) (err error) {
+	// Load the state of the last sync
+	basePath := syncBasePath(source, datasetID)
+	var base syncBase
+	if base, err = readSyncBase(basePath, datasetID); err != nil {
+		return err
+	}
+	if base.SyncedAt > 0 {
+		fmt.Println(fmt.Sprintf("Using sync base from %v (%v entries)", time.Unix(base.SyncedAt, 0).Format(time.RFC3339), len(base.Entries)))
+	} else {
+		fmt.Println("No sync base found. Entries which differ between source and target will be reported as conflicts.")
+	}
+
+	// Compare all three states. Entries which couldn't be read remotely must not be treated as deleted remotely.
+	unreadable, readable := splitEntriesByID(localData, invalidIDs)
+	result := mergeSyncedDatasets(readable, remoteData, base.Entries)
+	for _, entry := range unreadable {
+		result.Merged = append(result.Merged, entry)
+		if baseIdx := findEntryByID(entry.ID, base.Entries); baseIdx >= 0 {
+			result.KeepBase = append(result.KeepBase, base.Entries[baseIdx])
+		}
+	}
+	printSyncMerge(result)
+
+	// Only print the training requests if this is a dry run
+	if dryRun {
+		fmt.Println("Dry run: the following requests would be sent.")
+		if err = trainDatasetEntries(ctx, client, datasetID, result.Upload, result.Merged, remoteData); err != nil {
+			return err
+		}
+		fmt.Println(fmt.Sprintf("Dry run: source file %v and sync base %v would be updated", source, basePath))
+		return nil
+	}
+
+	// Upload local additions
+	if len(result.Upload) > 0 {
+		fmt.Println(fmt.Sprintf("Uploading %v local entries...", len(result.Upload)))
+		if err = trainDatasetEntries(ctx, client, datasetID, result.Upload, result.Merged, remoteData); err != nil {
+			return err
+		}
+
+		// Fetch remote state again to get the IDs of the uploaded entries
+		if remoteData, _, err = fetchDatasetEntries(ctx, client, datasetID); err != nil {
+			return err
+		}
+		assignRemoteIDs(result.Merged, remoteData)
+	}
+
+	// Write merged state to the source file
+	if err = writeDataset(source, result.Merged); err != nil {
+		return err
+	}
+
+	// Store the new sync base
+	base = syncBase{
+		DatasetID: datasetID,
+		SyncedAt:  time.Now().Unix(),
+		Entries:   buildSyncBase(result, remoteData),
+	}
+	if err = writeSyncBase(basePath, base); err != nil {
+		return err
+	}
+
+	if len(result.Conflicts) > 0 {
+		return fmt.Errorf("sync finished with %v conflicts, please resolve them manually", len(result.Conflicts))
+	}
+	fmt.Println("Sync done.")
+	return nil
+}
+
+// syncBase is the snapshot of a dataset at the time of the last sync
+type syncBase struct {
+	DatasetID string
+	SyncedAt  int64
+	Entries   []DatasetEntry
+}
+
+// syncBasePath returns the path of the sync base file for a source file and a dataset
+func syncBasePath(source, datasetID string) string {
+	return filepath.Join(filepath.Dir(source), filepath.FromSlash(syncBaseDir), fmt.Sprintf("%v.json", datasetID))
+}
+
+// readSyncBase reads the sync base file of a dataset. A missing file results in an empty sync base.
+// Fails if the file belongs to another dataset, since its entries would be mistaken for deleted ones.
+func readSyncBase(path, datasetID string) (base syncBase, err error) {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return base, nil
+	} else if err != nil {
+		return base, err
+	}
+	if err = json.Unmarshal(content, &base); err != nil {
+		return syncBase{}, fmt.Errorf("invalid sync base %v: %v", path, err)
+	}
+	if base.DatasetID != datasetID {
+		return syncBase{}, fmt.Errorf("sync base %v belongs to dataset %q instead of %q; delete it to start over", path, base.DatasetID, datasetID)
+	}
+	for _, entry := range base.Entries {
+		if entry.ID == "" {
+			return syncBase{}, fmt.Errorf("invalid sync base %v: entry U: '%v' K: '%v' has no ID", path, entry.UserMessage, entry.Message)
+		}
+	}
+	return base, nil
+}
+
+// writeSyncBase stores a sync base file, creating its directory if required
+func writeSyncBase(path string, base syncBase) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(base, "", "  ")
+	if err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(path, func(file io.Writer) error {
+		_, errWrite := file.Write(content)
+		return errWrite
+	})
+}
+
+// syncConflict describes an entry which has been changed on both sides since the last sync
+type syncConflict struct {
+	Reason string
+	Local  *DatasetEntry
+	Remote *DatasetEntry
+}
+
+// syncMerge holds the result of a three-way comparison
+type syncMerge struct {
+	// Merged is the new local state
+	Merged []DatasetEntry
+	// Upload contains local entries which need to be uploaded
+	Upload []DatasetEntry
+	// Pulled contains remote entries which have been added to or updated in the local state
+	Pulled []DatasetEntry
+	// Removed contains local entries which have been removed because they were deleted remotely
+	Removed []DatasetEntry
+	// Stale contains remote entries which were deleted or replaced locally, but can't be deleted via API
+	Stale []DatasetEntry
+	// Conflicts contains entries changed on both sides
+	Conflicts []syncConflict
+	// KeepBase contains base entries which have to be kept unchanged for the next sync
+	KeepBase []DatasetEntry
+}
+
+// mergeSyncedDatasets performs a three-way comparison of local, remote and base entries, matched by ID.
+// Local entries without an ID (or with an unknown ID) are new, unless the remote side has an entry with identical content.
+func mergeSyncedDatasets(localData, remoteData, baseData []DatasetEntry) syncMerge {
+	result := syncMerge{
+		Merged:    make([]DatasetEntry, 0, len(localData)),
+		Upload:    make([]DatasetEntry, 0),
+		Pulled:    make([]DatasetEntry, 0),
+		Removed:   make([]DatasetEntry, 0),
+		Stale:     make([]DatasetEntry, 0),
+		Conflicts: make([]syncConflict, 0),
+		KeepBase:  make([]DatasetEntry, 0),
+	}
+	handledRemote := make(map[string]bool)
+	newLocal := make([]DatasetEntry, 0)
+
+	// 1. Compare all local entries known to the remote side or the base
+	for _, localEntry := range localData {
+		localEntry := localEntry
+		remoteIdx := findEntryByID(localEntry.ID, remoteData)
+		baseIdx := findEntryByID(localEntry.ID, baseData)
+
+		switch {
+		case remoteIdx >= 0:
+			remoteEntry := &remoteData[remoteIdx]
+			handledRemote[remoteEntry.ID] = true
+
+			if isSyncEqual(&localEntry, remoteEntry) {
+				// Identical on both sides
+				result.Merged = append(result.Merged, localEntry)
+			} else if baseIdx >= 0 && isSyncEqual(&localEntry, &baseData[baseIdx]) {
+				// Changed remotely only
+				result.Merged = append(result.Merged, *remoteEntry)
+				result.Pulled = append(result.Pulled, *remoteEntry)
+			} else if baseIdx >= 0 && isSyncEqual(remoteEntry, &baseData[baseIdx]) && localEntry.Deleted {
+				// Deleted locally; the API can't delete entries, so keep reporting it until deleted via web app
+				result.Stale = append(result.Stale, *remoteEntry)
+				result.KeepBase = append(result.KeepBase, *remoteEntry)
+				result.Merged = append(result.Merged, localEntry)
+			} else if baseIdx >= 0 && isSyncEqual(remoteEntry, &baseData[baseIdx]) {
+				// Changed locally only; the API can't modify entries, so upload it as a new one
+				result.Stale = append(result.Stale, *remoteEntry)
+				result.KeepBase = append(result.KeepBase, *remoteEntry)
+				localEntry.ID = ""
+				result.Merged = append(result.Merged, localEntry)
+				result.Upload = append(result.Upload, localEntry)
+			} else {
+				// Changed on both sides, or no base to decide
+				result.Merged = append(result.Merged, localEntry)
+				result.Conflicts = append(result.Conflicts, syncConflict{Reason: "modified on both sides", Local: &localEntry, Remote: remoteEntry})
+				if baseIdx >= 0 {
+					result.KeepBase = append(result.KeepBase, baseData[baseIdx])
+				}
+			}
+		case baseIdx >= 0:
+			if isSyncEqual(&localEntry, &baseData[baseIdx]) {
+				// Deleted remotely
+				result.Removed = append(result.Removed, localEntry)
+			} else {
+				result.Merged = append(result.Merged, localEntry)
+				result.Conflicts = append(result.Conflicts, syncConflict{Reason: "modified locally, deleted remotely", Local: &localEntry})
+				result.KeepBase = append(result.KeepBase, baseData[baseIdx])
+			}
+		default:
+			newLocal = append(newLocal, localEntry)
+		}
+	}
+
+	// 2. Link new local entries to identical remote ones, or upload them
+	for _, localEntry := range newLocal {
+		linked := false
+		for i := range remoteData {
+			remoteEntry := &remoteData[i]
+			if !handledRemote[remoteEntry.ID] && localEntry.isDuplicate(remoteEntry) {
+				localEntry.ID = remoteEntry.ID
+				handledRemote[remoteEntry.ID] = true
+				linked = true
+				break
+			}
+		}
+		if !linked && !localEntry.Deleted {
+			localEntry.ID = ""
+			result.Upload = append(result.Upload, localEntry)
+		}
+		result.Merged = append(result.Merged, localEntry)
+	}
+
+	// 3. Check remaining remote entries
+	for _, remoteEntry := range remoteData {
+		remoteEntry := remoteEntry
+		if handledRemote[remoteEntry.ID] {
+			continue
+		}
+		baseIdx := findEntryByID(remoteEntry.ID, baseData)
+		switch {
+		case baseIdx < 0:
+			// Added remotely
+			result.Merged = append(result.Merged, remoteEntry)
+			result.Pulled = append(result.Pulled, remoteEntry)
+		case isSyncEqual(&remoteEntry, &baseData[baseIdx]):
+			// Deleted locally
+			result.Stale = append(result.Stale, remoteEntry)
+			result.KeepBase = append(result.KeepBase, remoteEntry)
+		default:
+			result.Conflicts = append(result.Conflicts, syncConflict{Reason: "deleted locally, modified remotely", Remote: &remoteEntry})
+			result.KeepBase = append(result.KeepBase, baseData[baseIdx])
+		}
+	}
+
+	return result
+}
+
+// isSyncEqual tells whether two entries have identical content and deletion state.
+// Unlike duplicates, entries differing in their deletion state are changed in terms of a sync.
+func isSyncEqual(a, b *DatasetEntry) bool {
+	return a.isDuplicate(b) && a.Deleted == b.Deleted
+}
+
+// buildSyncBase creates the base entries for the next sync from a merge result and the current remote state
+func buildSyncBase(result syncMerge, remoteData []DatasetEntry) []DatasetEntry {
+	baseEntries := make([]DatasetEntry, 0)
+	inBase := make(map[string]bool)
+	for _, entry := range result.KeepBase {
+		baseEntries = append(baseEntries, entry)
+		inBase[entry.ID] = true
+	}
+
+	// Conflicting entries must stay as they were in the old base
+	conflicting := make(map[string]bool)
+	for _, conflict := range result.Conflicts {
+		if conflict.Local != nil {
+			conflicting[conflict.Local.ID] = true
+		}
+		if conflict.Remote != nil {
+			conflicting[conflict.Remote.ID] = true
+		}
+	}
+
+	for _, entry := range result.Merged {
+		if inBase[entry.ID] || conflicting[entry.ID] || findEntryByID(entry.ID, remoteData) < 0 {
+			continue
+		}
+		entry.DuplicateIDs = nil
+		baseEntries = append(baseEntries, entry)
+		inBase[entry.ID] = true
+	}
+	return baseEntries
+}
 
-	// Cobra supports Persistent Flags which will work for this command
-	// and all subcommands, e.g.:
-	// syncCmd.PersistentFlags().String("foo", "", "A help for foo")
+// printSyncMerge prints a human readable report of a three-way comparison
+func printSyncMerge(result syncMerge) {
+	if len(result.Pulled) > 0 {
+		fmt.Println(fmt.Sprintf("\nPulled from remote (%v):", len(result.Pulled)))
+		for _, entry := range result.Pulled {
+			fmt.Println(fmt.Sprintf("< %v", describeDatasetEntry(entry)))
+		}
+	}
+	if len(result.Upload) > 0 {
+		fmt.Println(fmt.Sprintf("\nTo be uploaded (%v):", len(result.Upload)))
+		for _, entry := range result.Upload {
+			fmt.Println(fmt.Sprintf("> %v", describeDatasetEntry(entry)))
+		}
+	}
+	if len(result.Removed) > 0 {
+		fmt.Println(fmt.Sprintf("\nRemoved locally, deleted remotely (%v):", len(result.Removed)))
+		for _, entry := range result.Removed {
+			fmt.Println(fmt.Sprintf("- %v", describeDatasetEntry(entry)))
+		}
+	}
+	if len(result.Stale) > 0 {
+		fmt.Println(fmt.Sprintf("\nDeleted or replaced locally, please delete via web app (%v):", len(result.Stale)))
+		for _, entry := range result.Stale {
+			fmt.Println(fmt.Sprintf("- %v", describeDatasetEntry(entry)))
+		}
+	}
+	if len(result.Conflicts) > 0 {
+		fmt.Println(fmt.Sprintf("\nConflicts (%v):", len(result.Conflicts)))
+		for _, conflict := range result.Conflicts {
+			switch {
+			case conflict.Local != nil && conflict.Remote != nil:
+				fmt.Println(fmt.Sprintf("! %v: %v", conflict.Reason, describeDatasetEntry(*conflict.Local)))
+				for _, field := range compareDatasetEntries(conflict.Local, conflict.Remote) {
+					fmt.Println(fmt.Sprintf("    %v: local '%v' <=> remote '%v'", field.Field, field.Source, field.Target))
+				}
+			case conflict.Local != nil:
+				fmt.Println(fmt.Sprintf("! %v: %v", conflict.Reason, describeDatasetEntry(*conflict.Local)))
+			default:
+				fmt.Println(fmt.Sprintf("! %v: %v", conflict.Reason, describeDatasetEntry(*conflict.Remote)))
+			}
+		}
+	}
 
-	// Cobra supports local flags which will only run when this command
-	// is called directly, e.g.:
-	// syncCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	fmt.Println(fmt.Sprintf("\nSummary: %v pulled, %v to upload, %v removed, %v stale, %v conflicts.",
+		len(result.Pulled), len(result.Upload), len(result.Removed), len(result.Stale), len(result.Conflicts)))
 }
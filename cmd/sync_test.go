@@ -0,0 +1,543 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/runtimeracer/kajitool/query"
+)
+
+func TestFindMissingEntries(t *testing.T) {
+	deleted := newTestEntry("", "Deleted", "Gone")
+	deleted.Deleted = true
+
+	tests := []struct {
+		name    string
+		local   []DatasetEntry
+		remote  []DatasetEntry
+		missing []string
+	}{
+		{
+			name:   "present with same ID",
+			local:  []DatasetEntry{newTestEntry("a", "Hello", "Hi")},
+			remote: []DatasetEntry{newTestEntry("a", "Hello", "Hi")},
+		},
+		{
+			name:   "present with different ID",
+			local:  []DatasetEntry{newTestEntry("", "Hello", "Hi")},
+			remote: []DatasetEntry{newTestEntry("a", "Hello", "Hi")},
+		},
+		{
+			name:    "missing",
+			local:   []DatasetEntry{newTestEntry("", "Hello", "Hi"), newTestEntry("", "Bye", "See you")},
+			remote:  []DatasetEntry{newTestEntry("a", "Hello", "Hi")},
+			missing: []string{"Bye"},
+		},
+		{
+			name:    "changed content with known ID",
+			local:   []DatasetEntry{newTestEntry("a", "Hello", "Hey")},
+			remote:  []DatasetEntry{newTestEntry("a", "Hello", "Hi")},
+			missing: []string{"Hello"},
+		},
+		{
+			name:    "local duplicates",
+			local:   []DatasetEntry{newTestEntry("", "Hello", "Hi"), newTestEntry("", "Hello", "Hi")},
+			missing: []string{"Hello"},
+		},
+		{
+			name:  "deleted",
+			local: []DatasetEntry{deleted},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			missing := findMissingEntries(test.local, test.remote)
+			got := make([]string, len(missing))
+			for i, entry := range missing {
+				got[i] = entry.UserMessage
+			}
+			if !equalStrings(got, test.missing) {
+				t.Errorf("got %v, want %v", got, test.missing)
+			}
+		})
+	}
+}
+
+func TestAssignRemoteIDs(t *testing.T) {
+	tests := []struct {
+		name    string
+		local   []DatasetEntry
+		remote  []DatasetEntry
+		ids     []string
+		changed int
+	}{
+		{
+			name:   "already linked",
+			local:  []DatasetEntry{newTestEntry("a", "Hello", "Hi")},
+			remote: []DatasetEntry{newTestEntry("a", "Hello", "Hi")},
+			ids:    []string{"a"},
+		},
+		{
+			name:    "new entry",
+			local:   []DatasetEntry{newTestEntry("", "Hello", "Hi")},
+			remote:  []DatasetEntry{newTestEntry("a", "Hello", "Hi")},
+			ids:     []string{"a"},
+			changed: 1,
+		},
+		{
+			name:    "outdated ID",
+			local:   []DatasetEntry{newTestEntry("old", "Hello", "Hi")},
+			remote:  []DatasetEntry{newTestEntry("a", "Hello", "Hi")},
+			ids:     []string{"a"},
+			changed: 1,
+		},
+		{
+			name:   "no remote equivalent",
+			local:  []DatasetEntry{newTestEntry("", "Hello", "Hi")},
+			remote: []DatasetEntry{newTestEntry("a", "Bye", "See you")},
+			ids:    []string{""},
+		},
+		{
+			name:    "each remote ID is assigned once",
+			local:   []DatasetEntry{newTestEntry("", "Hello", "Hi"), newTestEntry("", "Hello", "Hi")},
+			remote:  []DatasetEntry{newTestEntry("a", "Hello", "Hi")},
+			ids:     []string{"a", ""},
+			changed: 1,
+		},
+		{
+			name:    "linked remote entries can't be claimed again",
+			local:   []DatasetEntry{newTestEntry("", "Hello", "Hi"), newTestEntry("a", "Hello", "Hi")},
+			remote:  []DatasetEntry{newTestEntry("a", "Hello", "Hi"), newTestEntry("b", "Hello", "Hi")},
+			ids:     []string{"b", "a"},
+			changed: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			changed := assignRemoteIDs(test.local, test.remote)
+			if changed != test.changed {
+				t.Errorf("changed: got %v, want %v", changed, test.changed)
+			}
+			if got := entryIDs(test.local); !equalStrings(got, test.ids) {
+				t.Errorf("IDs: got %v, want %v", got, test.ids)
+			}
+		})
+	}
+}
+
+func TestSync(t *testing.T) {
+	env := newTestEnvironment(t)
+	env.addDataset("ds1", testUserID, 0, newTestEntry("a", "Hello", "Hi"))
+
+	source := env.path("dataset.csv")
+	if err := writeDataset(source, []DatasetEntry{
+		newTestEntry("", "Hello", "Hi"),
+		newTestEntry("", "How are you?", "Fine"),
+		newTestEntry("", "Really?", "Yes", "How are you?"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := env.execute("dataset", "sync", "-s", source, "-t", "ds1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The missing history context is only uploaded along with its follow-up
+	requests := env.client.TrainingRequests
+	if len(requests) != 1 || len(requests[0]) != 2 {
+		t.Fatalf("expected a single training request of 2 entries, got %v", requests)
+	}
+	if count := len(env.client.GetDatasetEntries("ds1")); count != 3 {
+		t.Errorf("expected 3 entries in dataset, got %v", count)
+	}
+
+	// All IDs are written back into the source file
+	result, err := readDataset(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result[0].ID != "a" {
+		t.Errorf("expected existing entry to be linked to a, got %q", result[0].ID)
+	}
+	for _, entry := range result {
+		if entry.ID == "" {
+			t.Errorf("entry %q has no ID after sync", entry.UserMessage)
+		}
+	}
+
+	// A second sync has nothing to do
+	env.client.TrainingRequests = nil
+	if err = env.execute("dataset", "sync", "-s", source, "-t", "ds1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(env.client.TrainingRequests) > 0 {
+		t.Errorf("expected no training requests, got %v", len(env.client.TrainingRequests))
+	}
+}
+
+func TestSyncFollowUpOfRemoteContext(t *testing.T) {
+	env := newTestEnvironment(t)
+	env.addDataset("ds1", testUserID, 0, newTestEntry("a", "Bye", "See you"))
+
+	source := env.path("dataset.csv")
+	if err := writeDataset(source, []DatasetEntry{
+		newTestEntry("a", "Bye", "See you"),
+		newTestEntry("", "Really?", "Yes", "Bye"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The history context already exists remotely, so only the follow-up is sent
+	if err := env.execute("dataset", "sync", "-s", source, "-t", "ds1"); err != nil {
+		t.Fatal(err)
+	}
+	if count := len(env.client.GetDatasetEntries("ds1")); count != 2 {
+		t.Errorf("expected the dataset to grow by exactly one entry, got %v entries", count)
+	}
+	for _, request := range env.client.TrainingRequests {
+		for _, training := range request {
+			if training.UserMessage == "Bye" {
+				t.Errorf("history context sent again: %v", request)
+			}
+		}
+	}
+}
+
+func TestSyncMissingHistoryContext(t *testing.T) {
+	env := newTestEnvironment(t)
+	env.addDataset("ds1", testUserID, 0)
+
+	source := env.path("dataset.csv")
+	if err := writeDataset(source, []DatasetEntry{
+		newTestEntry("", "Really?", "Yes", "How are you?"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := env.execute("dataset", "sync", "-s", source, "-t", "ds1"); err == nil {
+		t.Fatal("expected sync of an entry without history context to fail")
+	}
+	if len(env.client.TrainingRequests) > 0 {
+		t.Error("no training requests expected")
+	}
+}
+
+func TestBuildEntryTrainingsWithoutContext(t *testing.T) {
+	entry := newTestEntry("", "Really?", "Yes", "How are you?")
+	if _, err := buildEntryTrainings(entry, []DatasetEntry{newTestEntry("", "Hello", "Hi"), entry}); err == nil {
+		t.Error("expected an error for an entry without matching history context")
+	}
+}
+
+func TestMergeSyncedDatasets(t *testing.T) {
+	hi := newTestEntry("a", "Hello", "Hi")
+	hey := newTestEntry("a", "Hello", "Hey")
+	howdy := newTestEntry("a", "Hello", "Howdy")
+	bye := newTestEntry("", "Bye", "See you")
+	deleted := newTestEntry("a", "Hello", "Hi")
+	deleted.Deleted = true
+
+	tests := []struct {
+		name      string
+		local     []DatasetEntry
+		remote    []DatasetEntry
+		base      []DatasetEntry
+		merged    []string
+		upload    []string
+		pulled    []string
+		removed   []string
+		stale     []string
+		conflicts int
+		nextBase  []string
+	}{
+		{
+			name:     "unchanged",
+			local:    []DatasetEntry{hi},
+			remote:   []DatasetEntry{hi},
+			base:     []DatasetEntry{hi},
+			merged:   []string{"a:Hi"},
+			nextBase: []string{"a:Hi"},
+		},
+		{
+			name:     "changed remotely",
+			local:    []DatasetEntry{hi},
+			remote:   []DatasetEntry{hey},
+			base:     []DatasetEntry{hi},
+			merged:   []string{"a:Hey"},
+			pulled:   []string{"a:Hey"},
+			nextBase: []string{"a:Hey"},
+		},
+		{
+			name:     "changed locally",
+			local:    []DatasetEntry{hey},
+			remote:   []DatasetEntry{hi},
+			base:     []DatasetEntry{hi},
+			merged:   []string{":Hey"},
+			upload:   []string{":Hey"},
+			stale:    []string{"a:Hi"},
+			nextBase: []string{"a:Hi"},
+		},
+		{
+			name:      "changed on both sides",
+			local:     []DatasetEntry{hey},
+			remote:    []DatasetEntry{howdy},
+			base:      []DatasetEntry{hi},
+			merged:    []string{"a:Hey"},
+			conflicts: 1,
+			nextBase:  []string{"a:Hi"},
+		},
+		{
+			name:     "changed identically on both sides",
+			local:    []DatasetEntry{hey},
+			remote:   []DatasetEntry{hey},
+			base:     []DatasetEntry{hi},
+			merged:   []string{"a:Hey"},
+			nextBase: []string{"a:Hey"},
+		},
+		{
+			name:    "deleted remotely",
+			local:   []DatasetEntry{hi},
+			base:    []DatasetEntry{hi},
+			removed: []string{"a:Hi"},
+		},
+		{
+			name:      "deleted remotely, changed locally",
+			local:     []DatasetEntry{hey},
+			base:      []DatasetEntry{hi},
+			merged:    []string{"a:Hey"},
+			conflicts: 1,
+			nextBase:  []string{"a:Hi"},
+		},
+		{
+			name:     "deleted locally",
+			remote:   []DatasetEntry{hi},
+			base:     []DatasetEntry{hi},
+			stale:    []string{"a:Hi"},
+			nextBase: []string{"a:Hi"},
+		},
+		{
+			name:     "marked as deleted locally",
+			local:    []DatasetEntry{deleted},
+			remote:   []DatasetEntry{hi},
+			base:     []DatasetEntry{hi},
+			merged:   []string{"a:Hi (deleted)"},
+			stale:    []string{"a:Hi"},
+			nextBase: []string{"a:Hi"},
+		},
+		{
+			name:      "marked as deleted locally, changed remotely",
+			local:     []DatasetEntry{deleted},
+			remote:    []DatasetEntry{hey},
+			base:      []DatasetEntry{hi},
+			merged:    []string{"a:Hi (deleted)"},
+			conflicts: 1,
+			nextBase:  []string{"a:Hi"},
+		},
+		{
+			name:     "marked as deleted remotely",
+			local:    []DatasetEntry{hi},
+			remote:   []DatasetEntry{deleted},
+			base:     []DatasetEntry{hi},
+			merged:   []string{"a:Hi (deleted)"},
+			pulled:   []string{"a:Hi (deleted)"},
+			nextBase: []string{"a:Hi (deleted)"},
+		},
+		{
+			name:      "deleted locally, changed remotely",
+			remote:    []DatasetEntry{hey},
+			base:      []DatasetEntry{hi},
+			conflicts: 1,
+			nextBase:  []string{"a:Hi"},
+		},
+		{
+			name:   "deleted on both sides",
+			base:   []DatasetEntry{hi},
+			merged: []string{},
+		},
+		{
+			name:     "added on both sides",
+			local:    []DatasetEntry{hi, bye},
+			remote:   []DatasetEntry{hi, newTestEntry("b", "Thanks", "You're welcome")},
+			base:     []DatasetEntry{hi},
+			merged:   []string{"a:Hi", ":See you", "b:You're welcome"},
+			upload:   []string{":See you"},
+			pulled:   []string{"b:You're welcome"},
+			nextBase: []string{"a:Hi", "b:You're welcome"},
+		},
+		{
+			name:     "first sync links identical entries",
+			local:    []DatasetEntry{newTestEntry("", "Hello", "Hi")},
+			remote:   []DatasetEntry{hi},
+			merged:   []string{"a:Hi"},
+			nextBase: []string{"a:Hi"},
+		},
+		{
+			name:      "first sync can't decide on differing entries",
+			local:     []DatasetEntry{hey},
+			remote:    []DatasetEntry{hi},
+			merged:    []string{"a:Hey"},
+			conflicts: 1,
+		},
+		{
+			name:     "first sync of an empty source",
+			remote:   []DatasetEntry{hi},
+			merged:   []string{"a:Hi"},
+			pulled:   []string{"a:Hi"},
+			nextBase: []string{"a:Hi"},
+		},
+		{
+			name:     "base entries unknown on both sides are dropped",
+			local:    []DatasetEntry{hi},
+			remote:   []DatasetEntry{hi},
+			base:     []DatasetEntry{hi, newTestEntry("gone", "Old", "Entry")},
+			merged:   []string{"a:Hi"},
+			nextBase: []string{"a:Hi"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := mergeSyncedDatasets(test.local, test.remote, test.base)
+			check := func(kind string, entries []DatasetEntry, want []string) {
+				if got := describeSyncEntries(entries); !equalStrings(got, want) {
+					t.Errorf("%v: got %v, want %v", kind, got, want)
+				}
+			}
+			check("merged", result.Merged, test.merged)
+			check("upload", result.Upload, test.upload)
+			check("pulled", result.Pulled, test.pulled)
+			check("removed", result.Removed, test.removed)
+			check("stale", result.Stale, test.stale)
+			check("next base", buildSyncBase(result, test.remote), test.nextBase)
+			if len(result.Conflicts) != test.conflicts {
+				t.Errorf("conflicts: got %v, want %v", len(result.Conflicts), test.conflicts)
+			}
+		})
+	}
+}
+
+func TestSyncBidirectional(t *testing.T) {
+	env := newTestEnvironment(t)
+	env.addDataset("ds1", testUserID, 0, newTestEntry("a", "Hello", "Hi"))
+
+	source := env.path("dataset.csv")
+	if err := writeDataset(source, []DatasetEntry{
+		newTestEntry("", "Hello", "Hi"),
+		newTestEntry("", "Bye", "See you"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// First sync: no base yet, the local addition is uploaded
+	if err := env.execute("dataset", "sync", "-s", source, "-t", "ds1", "--bidirectional"); err != nil {
+		t.Fatal(err)
+	}
+	if len(env.client.TrainingRequests) != 1 {
+		t.Fatalf("expected 1 training request, got %v", len(env.client.TrainingRequests))
+	}
+	base, err := readSyncBase(syncBasePath(source, "ds1"), "ds1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(base.Entries) != 2 || base.DatasetID != "ds1" {
+		t.Fatalf("unexpected sync base: %+v", base)
+	}
+
+	// Second sync: an entry added remotely is pulled, nothing is uploaded
+	added := newTestEntry("", "Thanks", "You're welcome")
+	if _, err = env.client.DoTrainDataset(context.Background(), "ds1", env.sessionKey, []query.AITraining{added.ToAITraining(0)}); err != nil {
+		t.Fatal(err)
+	}
+	env.client.TrainingRequests = nil
+	if err = env.execute("dataset", "sync", "-s", source, "-t", "ds1", "--bidirectional"); err != nil {
+		t.Fatal(err)
+	}
+	if len(env.client.TrainingRequests) > 0 {
+		t.Errorf("expected no training requests, got %v", len(env.client.TrainingRequests))
+	}
+	result, err := readDataset(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 3 || result[2].UserMessage != "Thanks" || result[2].ID == "" {
+		t.Errorf("expected the remote addition to be pulled, got %v", result)
+	}
+}
+
+func TestSyncInvalidRemoteEntry(t *testing.T) {
+	env := newTestEnvironment(t)
+	env.addDataset("ds1", testUserID, 0, newTestEntry("a", "Hello", "Hi"), newTestEntry("b", "Bye", "See you"))
+	state := env.client.State()
+	state.Datasets[0].Entries[1].ASM = "GIGGLY"
+	env.client.SetState(state)
+
+	source := env.path("dataset.csv")
+	local := []DatasetEntry{newTestEntry("a", "Hello", "Hi"), newTestEntry("b", "Bye", "See you")}
+	if err := writeDataset(source, local); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeSyncBase(syncBasePath(source, "ds1"), syncBase{DatasetID: "ds1", SyncedAt: 1, Entries: local}); err != nil {
+		t.Fatal(err)
+	}
+
+	// An entry which can't be read remotely is neither uploaded again nor treated as deleted remotely
+	for _, args := range [][]string{nil, {"--bidirectional"}} {
+		if err := env.execute(append([]string{"dataset", "sync", "-s", source, "-t", "ds1"}, args...)...); err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+		if len(env.client.TrainingRequests) > 0 {
+			t.Errorf("%v: expected no training requests, got %v", args, env.client.TrainingRequests)
+		}
+		result, err := readDataset(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := describeSyncEntries(result); !equalStrings(got, []string{"a:Hi", "b:See you"}) {
+			t.Errorf("%v: expected local entries to be kept, got %v", args, got)
+		}
+	}
+}
+
+func TestSyncBidirectionalForeignBase(t *testing.T) {
+	env := newTestEnvironment(t)
+	env.addDataset("ds1", testUserID, 0, newTestEntry("a", "Hello", "Hi"))
+
+	source := env.path("dataset.csv")
+	if err := writeDataset(source, []DatasetEntry{newTestEntry("a", "Hello", "Hi")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A base copied from another dataset would make all entries look deleted
+	if err := writeSyncBase(syncBasePath(source, "ds1"), syncBase{
+		DatasetID: "ds2",
+		SyncedAt:  1,
+		Entries:   []DatasetEntry{newTestEntry("x", "Other", "Dataset")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := env.execute("dataset", "sync", "-s", source, "-t", "ds1", "--bidirectional"); err == nil {
+		t.Fatal("expected sync with the base of another dataset to fail")
+	}
+	result, err := readDataset(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 1 || result[0].ID != "a" {
+		t.Errorf("source file must not be changed, got %v", result)
+	}
+}
+
+// describeSyncEntries returns 'ID:Message' of all entries
+func describeSyncEntries(entries []DatasetEntry) []string {
+	result := make([]string, len(entries))
+	for i, entry := range entries {
+		result[i] = entry.ID + ":" + entry.Message
+		if entry.Deleted {
+			result[i] += " (deleted)"
+		}
+	}
+	return result
+}
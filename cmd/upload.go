@@ -17,23 +17,37 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/runtimeracer/kajitool/query"
 	"github.com/spf13/cobra"
+	"os"
 	"sort"
-	"strings"
-	"time"
 )
 
+// Flags
+var batchSize int
+var resume bool
+var dryRun bool
+
 // uploadCmd represents the upload command
 var uploadCmd = &cobra.Command{
 	Use:   "upload",
 	Short: "Takes a training data from a specified source file and uploads it into a specified target dataset.",
 	Long: `upload fetches training data from the specified source file and uploads it into the specified target dataset. 
 
-param source: must be a local file. Data will be expected to be in csv format.
-param target: a full Kajiwoto dataset URL (including ID), or a Kajiwoto dataset ID.`,
+param source: must be a local file. Data will be expected to be in csv, json, jsonl or sqlite format, depending on file extension or --format.
+param target: a full Kajiwoto dataset URL (including ID), or a Kajiwoto dataset ID.
+
+By default, each entry is uploaded using a separate request. Use --batch-size to upload entries without history context
+in batches; if the API rejects a batch, its entries are uploaded one by one.
+
+Each trained entry is recorded in a journal next to the source file (<source>.kajitool-journal). If an upload gets interrupted,
+use --resume to continue it without uploading duplicates. Once done, the IDs of the uploaded entries are written back
+into the source file and the journal is removed.
+
+Use --dry-run to print the training requests which would be sent, without changing the dataset or the source file.`,
 	RunE: func(cmd *cobra.Command, args []string) (err error) {
 
 		// Sanity checks
@@ -46,7 +60,7 @@ param target: a full Kajiwoto dataset URL (including ID), or a Kajiwoto dataset
 
 		// Read data from source file
 		var trainingData []DatasetEntry
-		if trainingData, err = readCSV(source); err != nil {
+		if trainingData, err = readDataset(source); err != nil {
 			return err
 		}
 
@@ -59,12 +73,20 @@ param target: a full Kajiwoto dataset URL (including ID), or a Kajiwoto dataset
 		}
 		fmt.Println(fmt.Sprintf("Found %v new entries in source data", len(qualified)))
 
+		// Load session key from the credential store
+		if err = loadSessionKey(); err != nil {
+			return err
+		}
+
 		// Init Client
-		client := query.GetKajiwotoClient(endpoint)
+		client, err := newSessionClient(endpoint)
+		if err != nil {
+			return err
+		}
 
 		// Login via Session key
 		loginResult := query.LoginResult{}
-		if loginResult, err = client.DoLoginAuthToken(sessionKey); err != nil {
+		if loginResult, err = client.DoLoginAuthToken(cmd.Context(), sessionKey); err != nil {
 			return err
 		}
 
@@ -73,7 +95,7 @@ param target: a full Kajiwoto dataset URL (including ID), or a Kajiwoto dataset
 
 		// Get Info on the source Dataset
 		datasetInfo := query.AITrainerGroup{}
-		if datasetInfo, err = client.GetAITrainerGroup(target, sessionKey); err != nil {
+		if datasetInfo, err = client.GetAITrainerGroup(cmd.Context(), target, sessionKey); err != nil {
 			return err
 		}
 
@@ -85,42 +107,83 @@ param target: a full Kajiwoto dataset URL (including ID), or a Kajiwoto dataset
 			return errors.New("not your dataset! You cannot upload to foreign datasets")
 		}
 
-		// Perform Upload - FIXME: This currently can only do single / unrelated training upload
-		for _, qEntry := range qualified {
-			// Convert training information to a elements required by graphQL
-			trainings := make([]query.AITraining, 0)
-
-			if len(qEntry.History) > 0 {
-				// Find matching history dataset entry
-				bestMatch := findBestContextualMatch(qEntry, trainingData)
-
-				// Match is Training 0
-				trainings = append(trainings, bestMatch.ToAITraining(0))
-				// Actual Entry is Training 1
-				trainings = append(trainings, qEntry.ToAITraining(1))
-			} else if len(qEntry.History) > 1 {
-				return fmt.Errorf("error: Entry U: '%v' K: '%v' has too many history context items", qEntry.UserMessage, qEntry.Message)
-			} else {
-				// Default: No history
-				trainings = append(trainings, qEntry.ToAITraining(0))
+		// Open journal of trained entries
+		journalPath := uploadJournalPath(source)
+		if _, errStat := os.Stat(journalPath); errStat == nil && !resume {
+			// A dry run only reads the journal, so there's no risk of mixing up both uploads
+			if !dryRun {
+				return fmt.Errorf("found journal %v of an interrupted upload; use --resume to continue it, or delete the journal to start over", journalPath)
 			}
+			fmt.Println(fmt.Sprintf("Dry run: found journal %v of an interrupted upload; use --resume to skip its entries", journalPath))
+		}
+		var journal *uploadJournal
+		if dryRun {
+			journal, err = readUploadJournal(journalPath, string(datasetInfo.ID))
+		} else {
+			journal, err = openUploadJournal(journalPath, string(datasetInfo.ID))
+		}
+		if err != nil {
+			return err
+		}
 
-			trainingResult := query.TrainDatasetResult{}
-			if trainingResult, err = client.DoTrainDataset(string(datasetInfo.ID), sessionKey, trainings); err != nil {
-				return err
+		// Skip entries already trained by an interrupted upload
+		if resume {
+			remaining := make([]DatasetEntry, 0, len(qualified))
+			for i := range qualified {
+				if !journal.skip(&qualified[i]) {
+					remaining = append(remaining, qualified[i])
+				}
 			}
-			fmt.Println(fmt.Sprintf("Training successful. New entry count: %v", trainingResult.Count))
+			fmt.Println(fmt.Sprintf("Skipping %v entries already uploaded according to journal %v", len(qualified)-len(remaining), journalPath))
+			qualified = remaining
+		}
 
-			// Sleep 1s to not hammer the API too much.
-			time.Sleep(time.Second)
+		// Only print the training requests if this is a dry run
+		if dryRun {
+			fmt.Println("Dry run: the following requests would be sent.")
+			return newDatasetTrainer(client, string(datasetInfo.ID), trainingData, nil).train(cmd.Context(), qualified)
 		}
 
-		return nil
+		// Perform Upload
+		if err = newDatasetTrainer(client, string(datasetInfo.ID), trainingData, journal).train(cmd.Context(), qualified); err != nil {
+			_ = journal.close()
+			return err
+		}
+
+		// Nothing trained at all
+		if journal.size() == 0 {
+			return journal.remove()
+		}
+
+		// Write the IDs of trained entries back into the source file
+		var remoteData []DatasetEntry
+		if remoteData, _, err = fetchDatasetEntries(cmd.Context(), client, string(datasetInfo.ID)); err != nil {
+			_ = journal.close()
+			return err
+		}
+		assigned, missing := assignJournaledIDs(trainingData, remoteData, journal)
+		if assigned > 0 {
+			fmt.Println(fmt.Sprintf("Updating %v entry IDs in source file %v", assigned, source))
+			if err = writeDataset(source, trainingData); err != nil {
+				_ = journal.close()
+				return err
+			}
+		}
+		if missing > 0 {
+			fmt.Println(fmt.Sprintf("WARNING: Unable to find IDs for %v uploaded entries, keeping journal %v", missing, journalPath))
+			return journal.close()
+		}
+		return journal.remove()
 	},
 }
 
 func init() {
 	datasetCmd.AddCommand(uploadCmd)
+
+	// Flags for upload
+	uploadCmd.Flags().IntVar(&batchSize, "batch-size", 1, "amount of independent entries uploaded within a single request")
+	uploadCmd.Flags().BoolVar(&resume, "resume", false, "continue an interrupted upload, skipping all entries recorded in its journal")
+	uploadCmd.Flags().BoolVar(&dryRun, "dry-run", false, "print the training requests instead of sending them")
 }
 
 func validateUploadSource(source string) (string, error) {
@@ -136,10 +199,253 @@ func validateUploadTarget(target string) (string, error) {
 		return "", errors.New("empty target")
 	}
 
-	return target, nil
+	return parseDatasetID(target), nil
+}
+
+// trainDatasetEntries uploads the specified entries into a dataset.
+// trainingData is used for looking up the history context of the entries. History contexts already present in
+// remoteData aren't sent again; remoteData may be nil if the state of the dataset is unknown.
+func trainDatasetEntries(ctx context.Context, client query.KajiwotoClient, datasetID string, entries, trainingData, remoteData []DatasetEntry) error {
+	trainer := newDatasetTrainer(client, datasetID, trainingData, nil)
+	if remoteData != nil {
+		trainer.remoteData = append(make([]DatasetEntry, 0, len(remoteData)+len(entries)), remoteData...)
+	}
+	return trainer.train(ctx, entries)
+}
+
+// datasetTrainer uploads entries into a dataset and keeps track of the entry count reported by the API
+type datasetTrainer struct {
+	client       query.KajiwotoClient
+	datasetID    string
+	trainingData []DatasetEntry
+	// journal records trained entries, if set
+	journal *uploadJournal
+	// remoteData holds the entries present in the dataset, including those trained by this trainer, if known.
+	// History contexts present there aren't sent again along with their follow-ups.
+	remoteData []DatasetEntry
+	// dryRun only prints the training requests instead of sending them
+	dryRun    bool
+	lastCount int
+	requests  int
+}
+
+// newDatasetTrainer creates a trainer for the specified dataset. journal may be nil.
+func newDatasetTrainer(client query.KajiwotoClient, datasetID string, trainingData []DatasetEntry, journal *uploadJournal) *datasetTrainer {
+	return &datasetTrainer{
+		client:       client,
+		datasetID:    datasetID,
+		trainingData: trainingData,
+		journal:      journal,
+		dryRun:       dryRun,
+		lastCount:    -1,
+	}
+}
+
+// train uploads the specified entries.
+// If batching is enabled, entries without history context are grouped into multi training requests; all others are
+// uploaded using one training request per entry.
+func (t *datasetTrainer) train(ctx context.Context, entries []DatasetEntry) (err error) {
+	batch := make([]DatasetEntry, 0, batchSize)
+	deferred := t.findDeferredContexts(entries)
+
+	for i, qEntry := range entries {
+		// Uploaded along with its first follow-up instead
+		if deferred[i] {
+			continue
+		}
+		if batchSize > 1 && len(qEntry.History) == 0 {
+			if batch = append(batch, qEntry); len(batch) >= batchSize {
+				if err = t.trainBatch(ctx, batch); err != nil {
+					return err
+				}
+				batch = batch[:0]
+			}
+			continue
+		}
+
+		if err = t.trainEntry(ctx, qEntry); err != nil {
+			return err
+		}
+	}
+
+	// Upload remaining batch
+	if len(batch) > 0 {
+		if err = t.trainBatch(ctx, batch); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// findDeferredContexts returns the indexes of entries which are the history context of another entry and missing in
+// the dataset. Those are uploaded along with their first follow-up, so they aren't trained twice.
+func (t *datasetTrainer) findDeferredContexts(entries []DatasetEntry) map[int]bool {
+	deferred := make(map[int]bool)
+	if t.remoteData == nil {
+		return deferred
+	}
+	for _, qEntry := range entries {
+		if len(qEntry.History) == 0 {
+			continue
+		}
+		contextEntry, err := findBestContextualMatch(qEntry, t.trainingData)
+		if err != nil || t.isPresent(&contextEntry) {
+			continue
+		}
+		if idx := findDuplicateEntry(&contextEntry, entries); idx >= 0 && len(entries[idx].History) == 0 {
+			deferred[idx] = true
+		}
+	}
+	return deferred
+}
+
+// isPresent tells whether an entry is known to be present in the dataset
+func (t *datasetTrainer) isPresent(entry *DatasetEntry) bool {
+	return t.remoteData != nil && findDuplicateEntry(entry, t.remoteData) >= 0
+}
+
+// trainEntry uploads a single entry, along with its history context if available and not yet present in the dataset
+func (t *datasetTrainer) trainEntry(ctx context.Context, qEntry DatasetEntry) (err error) {
+	// Convert training information to a elements required by graphQL.
+	// The history context is sent along with the entry, unless it's already present in the dataset.
+	trained := []DatasetEntry{qEntry}
+	trainings := []query.AITraining{qEntry.ToAITraining(0)}
+	if len(qEntry.History) > 0 {
+		contextEntry, errMatch := findBestContextualMatch(qEntry, t.trainingData)
+		if errMatch != nil {
+			return errMatch
+		}
+		if !t.isPresent(&contextEntry) {
+			if trainings, err = buildEntryTrainings(qEntry, t.trainingData); err != nil {
+				return err
+			}
+			trained = []DatasetEntry{contextEntry, qEntry}
+		}
+	}
+	if t.dryRun {
+		t.plan("training", trainings)
+		t.markPresent(trained)
+		return nil
+	}
+
+	trainingResult := query.TrainDatasetResult{}
+	if trainingResult, err = t.client.DoTrainDataset(ctx, t.datasetID, sessionKey, trainings); err != nil {
+		return err
+	}
+	t.markPresent(trained)
+	if err = t.report("Training successful.", trainingResult, []DatasetEntry{qEntry}, len(trainings)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// markPresent records trained entries as present in the dataset, if its state is known
+func (t *datasetTrainer) markPresent(trained []DatasetEntry) {
+	if t.remoteData != nil {
+		t.remoteData = append(t.remoteData, trained...)
+	}
 }
 
-func findBestContextualMatch(contextEntry DatasetEntry, trainingData []DatasetEntry) DatasetEntry {
+// trainBatch uploads independent entries within a single multi training request.
+// Falls back to single uploads if the request is rejected.
+func (t *datasetTrainer) trainBatch(ctx context.Context, batch []DatasetEntry) (err error) {
+	trainings := make([]query.AITraining, len(batch))
+	for i, bEntry := range batch {
+		trainings[i] = bEntry.ToAITraining(i)
+	}
+	if t.dryRun {
+		t.plan("multi training", trainings)
+		t.markPresent(batch)
+		return nil
+	}
+
+	trainingResult, errBatch := t.client.DoTrainDatasetMulti(ctx, t.datasetID, sessionKey, trainings)
+	if errBatch != nil {
+		// Only a batch refused by the server is known not to be trained; others could be trained already
+		if !errors.Is(errBatch, query.ErrTrainingRejected) {
+			return fmt.Errorf("unable to train batch of %v entries, it may have been trained nevertheless; check the dataset before resuming the upload. error: %v", len(batch), errBatch)
+		}
+		fmt.Println(fmt.Sprintf("WARNING: Batch of %v entries rejected, uploading them one by one. error: %v", len(batch), errBatch))
+		for _, bEntry := range batch {
+			if err = t.trainEntry(ctx, bEntry); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	t.markPresent(batch)
+	if err = t.report(fmt.Sprintf("Batch of %v entries trained successfully.", len(batch)), trainingResult, batch, len(trainings)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// plan prints a training request instead of sending it
+func (t *datasetTrainer) plan(kind string, trainings []query.AITraining) {
+	t.requests++
+	fmt.Println(fmt.Sprintf("Request #%v: %v of %v entries", t.requests, kind, len(trainings)))
+	for i, training := range trainings {
+		fmt.Println(fmt.Sprintf("  [%v] U: '%v' K: '%v' Condition: '%v'", i, training.UserMessage, training.Message, training.Condition))
+	}
+}
+
+// report prints the result of a training request and records the trained entries in the journal.
+// Warns if the entry count increased by less than the amount of trainings sent.
+func (t *datasetTrainer) report(message string, result query.TrainDatasetResult, trained []DatasetEntry, trainings int) error {
+	count := int(result.Count)
+	if t.lastCount < 0 {
+		fmt.Println(fmt.Sprintf("%v New entry count: %v", message, count))
+	} else {
+		added := count - t.lastCount
+		fmt.Println(fmt.Sprintf("%v New entry count: %v (+%v)", message, count, added))
+		if added < trainings {
+			fmt.Println(fmt.Sprintf("WARNING: Sent %v trainings, but entry count only increased by %v", trainings, added))
+		}
+	}
+	t.lastCount = count
+
+	if t.journal != nil {
+		for i := range trained {
+			if err := t.journal.record(&trained[i], count); err != nil {
+				return fmt.Errorf("unable to write upload journal: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// buildEntryTrainings converts a dataset entry into the trainings required to upload it.
+// If the entry has a history context, the best matching context entry is uploaded along with it.
+func buildEntryTrainings(qEntry DatasetEntry, trainingData []DatasetEntry) ([]query.AITraining, error) {
+	trainings := make([]query.AITraining, 0)
+
+	if len(qEntry.History) > 0 {
+		// Find matching history dataset entry
+		bestMatch, err := findBestContextualMatch(qEntry, trainingData)
+		if err != nil {
+			return nil, err
+		}
+
+		// Match is Training 0
+		trainings = append(trainings, bestMatch.ToAITraining(0))
+		// Actual Entry is Training 1
+		trainings = append(trainings, qEntry.ToAITraining(1))
+	} else if len(qEntry.History) > 1 {
+		return nil, fmt.Errorf("error: Entry U: '%v' K: '%v' has too many history context items", qEntry.UserMessage, qEntry.Message)
+	} else {
+		// Default: No history
+		trainings = append(trainings, qEntry.ToAITraining(0))
+	}
+
+	return trainings, nil
+}
+
+// findBestContextualMatch returns the entry answering the history context of contextEntry, preferring the one with
+// the most similar ASM and condition. Fails if there is no entry answering the history context at all.
+func findBestContextualMatch(contextEntry DatasetEntry, trainingData []DatasetEntry) (DatasetEntry, error) {
 	// Calculate matching points based on Condition & ASM
 	historyContent := contextEntry.History[0]
 	matchKeys := make([]int, 0)
@@ -154,10 +460,18 @@ func findBestContextualMatch(contextEntry DatasetEntry, trainingData []DatasetEn
 			}
 
 			// Condition
-			conditionVars := strings.Split(contextEntry.Condition, "")
-			matchConditionVars := strings.Split(match.Condition, "")
-			for i, qCond := range conditionVars {
-				if qCond == matchConditionVars[i] {
+			condition, matchCondition := contextEntry.Condition, match.Condition
+			if condition.Daytime == matchCondition.Daytime {
+				matchPoints++
+			}
+			if condition.LastSeen == matchCondition.LastSeen {
+				matchPoints++
+			}
+			if condition.Attachment == matchCondition.Attachment {
+				matchPoints++
+			}
+			for i, digit := range condition.Reserved {
+				if digit == matchCondition.Reserved[i] {
 					matchPoints++
 				}
 			}
@@ -170,8 +484,12 @@ func findBestContextualMatch(contextEntry DatasetEntry, trainingData []DatasetEn
 		}
 	}
 
+	if len(matchKeys) == 0 {
+		return DatasetEntry{}, fmt.Errorf("error: Entry U: '%v' K: '%v' has no entry matching its history context '%v'", contextEntry.UserMessage, contextEntry.Message, historyContent)
+	}
+
 	// Get best match
 	sort.Ints(matchKeys)
 	bestMatch, _ := matchMap[matchKeys[len(matchKeys)-1]]
-	return bestMatch
+	return bestMatch, nil
 }
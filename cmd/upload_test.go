@@ -1 +1,323 @@
 package cmd
+
+import (
+	"context"
+	"errors"
+	"github.com/runtimeracer/kajitool/query"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestUpload(t *testing.T) {
+	env := newTestEnvironment(t)
+	env.addDataset("ds1", testUserID, 0, newTestEntry("", "Hello", "Hi"))
+
+	source := env.path("dataset.csv")
+	if err := writeDataset(source, []DatasetEntry{
+		newTestEntry("existing", "Hello", "Hi"),
+		newTestEntry("", "How are you?", "Fine"),
+		newTestEntry("", "Really?", "Yes", "How are you?"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := env.execute("dataset", "upload", "-s", source, "-t", "ds1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// One request per new entry; the one with history context is uploaded along with its context
+	requests := env.client.TrainingRequests
+	if len(requests) != 2 {
+		t.Fatalf("expected 2 training requests, got %v", len(requests))
+	}
+	if len(requests[0]) != 1 || requests[0][0].UserMessage != "How are you?" {
+		t.Errorf("unexpected first training request: %v", requests[0])
+	}
+	if len(requests[1]) != 2 || requests[1][0].UserMessage != "How are you?" || requests[1][1].UserMessage != "Really?" {
+		t.Errorf("unexpected second training request: %v", requests[1])
+	}
+	if condition := requests[0][0].Condition; condition != "##0020##0##0" {
+		t.Errorf("unexpected training condition %v", condition)
+	}
+	if count := len(env.client.GetDatasetEntries("ds1")); count != 4 {
+		t.Errorf("expected 4 entries in dataset, got %v", count)
+	}
+}
+
+func TestUploadForeignDataset(t *testing.T) {
+	env := newTestEnvironment(t)
+	env.addDataset("ds1", "someone-else", 0)
+
+	source := env.path("dataset.csv")
+	if err := writeDataset(source, []DatasetEntry{newTestEntry("", "Hello", "Hi")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := env.execute("dataset", "upload", "-s", source, "-t", "ds1"); err == nil {
+		t.Fatal("expected upload to a foreign dataset to fail")
+	}
+	if len(env.client.TrainingRequests) > 0 {
+		t.Error("no training requests expected")
+	}
+}
+
+func TestUploadBatches(t *testing.T) {
+	env := newTestEnvironment(t)
+	env.addDataset("ds1", testUserID, 0)
+
+	source := env.path("dataset.csv")
+	if err := writeDataset(source, []DatasetEntry{
+		newTestEntry("", "Hello", "Hi"),
+		newTestEntry("", "How are you?", "Fine"),
+		newTestEntry("", "Bye", "See you"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := env.execute("dataset", "upload", "-s", source, "-t", "ds1", "--batch-size", "2"); err != nil {
+		t.Fatal(err)
+	}
+
+	if env.client.MultiRequests != 2 {
+		t.Errorf("expected 2 batch requests, got %v", env.client.MultiRequests)
+	}
+	for _, entry := range env.client.GetDatasetEntries("ds1") {
+		if len(entry.History) > 0 {
+			t.Errorf("batched entry %v must not have history", entry.ID)
+		}
+	}
+	if count := len(env.client.GetDatasetEntries("ds1")); count != 3 {
+		t.Errorf("expected 3 entries in dataset, got %v", count)
+	}
+}
+
+func TestUploadBatchFallback(t *testing.T) {
+	env := newTestEnvironment(t)
+	env.addDataset("ds1", testUserID, 0)
+	env.client.RejectMulti = true
+
+	source := env.path("dataset.csv")
+	if err := writeDataset(source, []DatasetEntry{
+		newTestEntry("", "Hello", "Hi"),
+		newTestEntry("", "How are you?", "Fine"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := env.execute("dataset", "upload", "-s", source, "-t", "ds1", "--batch-size", "10"); err != nil {
+		t.Fatal(err)
+	}
+
+	if requests := len(env.client.TrainingRequests); requests != 2 {
+		t.Errorf("expected 2 single training requests, got %v", requests)
+	}
+}
+
+func TestUploadBatchFailedAfterTraining(t *testing.T) {
+	env := newTestEnvironment(t)
+	env.addDataset("ds1", testUserID, 0)
+	env.client.FailMultiAfterTraining = true
+
+	source := env.path("dataset.csv")
+	if err := writeDataset(source, []DatasetEntry{
+		newTestEntry("", "Hello", "Hi"),
+		newTestEntry("", "How are you?", "Fine"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The batch might have been trained, so it must not be uploaded again one by one
+	if err := env.execute("dataset", "upload", "-s", source, "-t", "ds1", "--batch-size", "10"); err == nil {
+		t.Fatal("expected upload to fail")
+	}
+	if requests := len(env.client.TrainingRequests); requests != 1 {
+		t.Errorf("expected 1 training request, got %v", requests)
+	}
+	if count := len(env.client.GetDatasetEntries("ds1")); count != 2 {
+		t.Errorf("expected 2 entries in dataset, got %v", count)
+	}
+}
+
+func TestUploadWritesBackIDs(t *testing.T) {
+	env := newTestEnvironment(t)
+	env.addDataset("ds1", testUserID, 0)
+
+	source := env.path("dataset.csv")
+	if err := writeDataset(source, []DatasetEntry{
+		newTestEntry("", "Hello", "Hi"),
+		newTestEntry("", "How are you?", "Fine"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := env.execute("dataset", "upload", "-s", source, "-t", "ds1"); err != nil {
+		t.Fatal(err)
+	}
+
+	written, err := readDataset(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range written {
+		if entry.ID == "" {
+			t.Errorf("expected ID to be written back for entry '%v'", entry.UserMessage)
+		}
+	}
+	if _, err = os.Stat(uploadJournalPath(source)); !os.IsNotExist(err) {
+		t.Error("expected journal to be removed after a complete upload")
+	}
+}
+
+func TestUploadResume(t *testing.T) {
+	env := newTestEnvironment(t)
+	env.addDataset("ds1", testUserID, 0)
+
+	entries := []DatasetEntry{
+		newTestEntry("", "Hello", "Hi"),
+		newTestEntry("", "How are you?", "Fine"),
+	}
+	source := env.path("dataset.csv")
+	if err := writeDataset(source, entries); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate an upload interrupted after the first entry
+	if _, err := env.client.DoTrainDataset(context.Background(), "ds1", env.sessionKey, []query.AITraining{entries[0].ToAITraining(0)}); err != nil {
+		t.Fatal(err)
+	}
+	journal, err := openUploadJournal(uploadJournalPath(source), "ds1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = journal.record(&entries[0], 1); err != nil {
+		t.Fatal(err)
+	}
+	if err = journal.close(); err != nil {
+		t.Fatal(err)
+	}
+	env.client.TrainingRequests = nil
+
+	if err = env.execute("dataset", "upload", "-s", source, "-t", "ds1"); err == nil {
+		t.Fatal("expected upload to fail without --resume while a journal exists")
+	}
+	if err = env.execute("dataset", "upload", "-s", source, "-t", "ds1", "--resume"); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := env.client.TrainingRequests
+	if len(requests) != 1 || requests[0][0].UserMessage != "How are you?" {
+		t.Errorf("expected only the second entry to be uploaded, got %v", requests)
+	}
+	if count := len(env.client.GetDatasetEntries("ds1")); count != 2 {
+		t.Errorf("expected 2 entries in dataset, got %v", count)
+	}
+	written, err := readDataset(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range written {
+		if entry.ID == "" {
+			t.Errorf("expected ID to be written back for entry '%v'", entry.UserMessage)
+		}
+	}
+}
+
+func TestUploadDryRun(t *testing.T) {
+	env := newTestEnvironment(t)
+	env.addDataset("ds1", testUserID, 0)
+
+	source := env.path("dataset.csv")
+	if err := writeDataset(source, []DatasetEntry{
+		newTestEntry("", "Hello", "Hi"),
+		newTestEntry("", "How are you?", "Fine", "Hello"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := env.execute("dataset", "upload", "-s", source, "-t", "ds1", "--dry-run"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(env.client.TrainingRequests) > 0 || env.client.MultiRequests > 0 {
+		t.Error("no training requests expected on a dry run")
+	}
+	if _, err := os.Stat(uploadJournalPath(source)); !os.IsNotExist(err) {
+		t.Error("no journal expected on a dry run")
+	}
+	written, err := readDataset(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range written {
+		if entry.ID != "" {
+			t.Errorf("source file must not be changed on a dry run, got ID %v", entry.ID)
+		}
+	}
+}
+
+func TestTrainingStopsOnCancellation(t *testing.T) {
+	env := newTestEnvironment(t)
+	env.addDataset("ds1", testUserID, 0)
+	resetFlags(rootCmd)
+	sessionKey = env.sessionKey
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	entries := []DatasetEntry{newTestEntry("", "Hello", "Hi")}
+	if err := trainDatasetEntries(ctx, env.client, "ds1", entries, entries, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected training to be cancelled, got %v", err)
+	}
+	if len(env.client.TrainingRequests) > 0 {
+		t.Error("no training requests expected after cancellation")
+	}
+}
+
+func TestUploadDryRunWithJournal(t *testing.T) {
+	env := newTestEnvironment(t)
+	env.addDataset("ds1", testUserID, 0)
+
+	entries := []DatasetEntry{
+		newTestEntry("", "Hello", "Hi"),
+		newTestEntry("", "How are you?", "Fine"),
+	}
+	source := env.path("dataset.csv")
+	if err := writeDataset(source, entries); err != nil {
+		t.Fatal(err)
+	}
+
+	// Journal of an upload interrupted after the first entry
+	journalPath := uploadJournalPath(source)
+	journal, err := openUploadJournal(journalPath, "ds1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = journal.record(&entries[0], 1); err != nil {
+		t.Fatal(err)
+	}
+	if err = journal.close(); err != nil {
+		t.Fatal(err)
+	}
+	before, err := ioutil.ReadFile(journalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range [][]string{{"--dry-run"}, {"--dry-run", "--resume"}} {
+		if err = env.execute(append([]string{"dataset", "upload", "-s", source, "-t", "ds1"}, args...)...); err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+	}
+
+	if len(env.client.TrainingRequests) > 0 || env.client.MultiRequests > 0 {
+		t.Error("no training requests expected on a dry run")
+	}
+	after, err := ioutil.ReadFile(journalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(before) != string(after) {
+		t.Error("journal must not be changed on a dry run")
+	}
+}
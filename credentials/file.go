@@ -0,0 +1,202 @@
+package credentials
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/runtimeracer/kajitool/util"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	fileVersion = 1
+	fileKDF     = "scrypt"
+	// fileMode makes sure only the current user can read the credentials file
+	fileMode = 0600
+
+	// scrypt parameters recommended for interactive logins
+	scryptN      = 32768
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+	saltLen      = 16
+)
+
+// fileEnvelope is the content of the credentials file. The nonce is regenerated on each write, while the salt is
+// kept for the lifetime of the file, so the derived key stays valid.
+type fileEnvelope struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// FileStore stores secrets in a file encrypted with AES-GCM, using a key derived from a passphrase via scrypt
+type FileStore struct {
+	path       string
+	passphrase func() ([]byte, error)
+	key        []byte
+}
+
+// NewFileStore creates a store backed by the encrypted file at path.
+// The passphrase is only requested once the file is accessed for the first time.
+func NewFileStore(path string, passphrase func() ([]byte, error)) *FileStore {
+	return &FileStore{
+		path:       path,
+		passphrase: passphrase,
+	}
+}
+
+// Get returns the secret of the account, or ErrNotFound
+func (s *FileStore) Get(account string) (string, error) {
+	secrets, _, err := s.read()
+	if err != nil {
+		return "", err
+	}
+	secret, ok := secrets[account]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return secret, nil
+}
+
+// Set stores the secret of the account, replacing an existing one
+func (s *FileStore) Set(account, secret string) error {
+	secrets, envelope, err := s.read()
+	if err != nil {
+		return err
+	}
+	secrets[account] = secret
+	return s.write(secrets, envelope)
+}
+
+// Delete removes the secret of the account
+func (s *FileStore) Delete(account string) error {
+	secrets, envelope, err := s.read()
+	if err != nil {
+		return err
+	}
+	if _, ok := secrets[account]; !ok {
+		return nil
+	}
+	delete(secrets, account)
+	return s.write(secrets, envelope)
+}
+
+// read decrypts all secrets of the file. A missing file contains no secrets.
+func (s *FileStore) read() (secrets map[string]string, envelope *fileEnvelope, err error) {
+	secrets = make(map[string]string)
+	content, err := ioutil.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return secrets, nil, nil
+	} else if err != nil {
+		return secrets, nil, err
+	}
+
+	envelope = &fileEnvelope{}
+	if err = json.Unmarshal(content, envelope); err != nil {
+		return secrets, nil, fmt.Errorf("unable to read credentials file %v: %w", s.path, err)
+	}
+	if envelope.Version != fileVersion || envelope.KDF != fileKDF {
+		return secrets, nil, fmt.Errorf("unsupported credentials file %v (version %v, kdf %q)", s.path, envelope.Version, envelope.KDF)
+	}
+
+	key, err := s.deriveKey(envelope)
+	if err != nil {
+		return secrets, nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return secrets, nil, err
+	}
+	plaintext, err := aead.Open(nil, envelope.Nonce, envelope.Ciphertext, nil)
+	if err != nil {
+		return secrets, nil, ErrInvalidPassphrase
+	}
+	if err = json.Unmarshal(plaintext, &secrets); err != nil {
+		return secrets, nil, ErrInvalidPassphrase
+	}
+	return secrets, envelope, nil
+}
+
+// write encrypts all secrets into the file, keeping the salt of the previous envelope if there is one
+func (s *FileStore) write(secrets map[string]string, previous *fileEnvelope) error {
+	envelope := &fileEnvelope{
+		Version: fileVersion,
+		KDF:     fileKDF,
+		N:       scryptN,
+		R:       scryptR,
+		P:       scryptP,
+	}
+	if previous != nil {
+		envelope.Salt = previous.Salt
+		envelope.N, envelope.R, envelope.P = previous.N, previous.R, previous.P
+	} else {
+		envelope.Salt = make([]byte, saltLen)
+		if _, err := io.ReadFull(rand.Reader, envelope.Salt); err != nil {
+			return err
+		}
+	}
+
+	key, err := s.deriveKey(envelope)
+	if err != nil {
+		return err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+	plaintext, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+	envelope.Nonce = make([]byte, aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, envelope.Nonce); err != nil {
+		return err
+	}
+	envelope.Ciphertext = aead.Seal(nil, envelope.Nonce, plaintext, nil)
+
+	return util.WriteFileAtomicPerm(s.path, fileMode, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(envelope)
+	})
+}
+
+// deriveKey derives the encryption key from the passphrase. The key is cached, since the salt only changes
+// when a new file is created.
+func (s *FileStore) deriveKey(envelope *fileEnvelope) ([]byte, error) {
+	if s.key != nil {
+		return s.key, nil
+	}
+	passphrase, err := s.passphrase()
+	if err != nil {
+		return nil, err
+	}
+	if len(passphrase) == 0 {
+		return nil, errors.New("empty passphrase")
+	}
+	key, err := scrypt.Key(passphrase, envelope.Salt, envelope.N, envelope.R, envelope.P, scryptKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	s.key = key
+	return key, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
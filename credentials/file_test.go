@@ -0,0 +1,94 @@
+package credentials
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func staticPassphrase(passphrase string) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		return []byte(passphrase), nil
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials")
+	store := NewFileStore(path, staticPassphrase("correct horse"))
+
+	if _, err := store.Get("tester"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound on missing file, got %v", err)
+	}
+	if err := store.Set("tester", "session-secret"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("other", "other-secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected file mode 0600, got %v", info.Mode().Perm())
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), "session-secret") {
+		t.Error("secret stored in plaintext")
+	}
+
+	// A new store with the same passphrase reads the secrets
+	reopened := NewFileStore(path, staticPassphrase("correct horse"))
+	if secret, err := reopened.Get("tester"); err != nil || secret != "session-secret" {
+		t.Errorf("expected stored secret, got %q, %v", secret, err)
+	}
+	if err = reopened.Delete("other"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = reopened.Get("other"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound after delete, got %v", err)
+	}
+	if secret, err := reopened.Get("tester"); err != nil || secret != "session-secret" {
+		t.Errorf("expected remaining secret to be kept, got %q, %v", secret, err)
+	}
+}
+
+func TestFileStoreInvalidPassphrase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials")
+	if err := NewFileStore(path, staticPassphrase("correct horse")).Set("tester", "session-secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewFileStore(path, staticPassphrase("wrong"))
+	if _, err := store.Get("tester"); !errors.Is(err, ErrInvalidPassphrase) {
+		t.Errorf("expected ErrInvalidPassphrase, got %v", err)
+	}
+	if err := store.Set("tester", "overwritten"); !errors.Is(err, ErrInvalidPassphrase) {
+		t.Errorf("expected ErrInvalidPassphrase on write, got %v", err)
+	}
+	if err := NewFileStore(path, staticPassphrase("")).Set("tester", "overwritten"); err == nil {
+		t.Error("expected error on empty passphrase")
+	}
+}
+
+func TestParseReference(t *testing.T) {
+	reference, err := ParseReference("keyring:tester")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reference.Backend != BackendKeyring || reference.Account != "tester" || reference.String() != "keyring:tester" {
+		t.Errorf("unexpected reference %+v", reference)
+	}
+	for _, invalid := range []string{"", "file", "file:", "vault:tester"} {
+		if _, err = ParseReference(invalid); err == nil {
+			t.Errorf("expected error parsing %q", invalid)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package credentials
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/zalando/go-keyring"
+)
+
+// KeyringStore stores secrets in the keyring of the operating system, i.e. the macOS Keychain,
+// the Windows Credential Manager or a Secret Service provider like GNOME Keyring on Linux.
+type KeyringStore struct {
+	service string
+}
+
+// NewKeyringStore creates a store for secrets of the specified service
+func NewKeyringStore(service string) *KeyringStore {
+	return &KeyringStore{service: service}
+}
+
+// Get returns the secret of the account, or ErrNotFound
+func (s *KeyringStore) Get(account string) (string, error) {
+	secret, err := keyring.Get(s.service, account)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return "", ErrNotFound
+	} else if err != nil {
+		return "", keyringError(err)
+	}
+	return secret, nil
+}
+
+// Set stores the secret of the account, replacing an existing one
+func (s *KeyringStore) Set(account, secret string) error {
+	if err := keyring.Set(s.service, account, secret); err != nil {
+		return keyringError(err)
+	}
+	return nil
+}
+
+// Delete removes the secret of the account
+func (s *KeyringStore) Delete(account string) error {
+	if err := keyring.Delete(s.service, account); err != nil && !errors.Is(err, keyring.ErrNotFound) {
+		return keyringError(err)
+	}
+	return nil
+}
+
+// keyringError explains that the keyring may not be available, e.g. on headless systems without a Secret Service
+func keyringError(err error) error {
+	return fmt.Errorf("OS keyring not available, use the file credential store instead: %w", err)
+}
@@ -0,0 +1,63 @@
+package credentials
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// BackendFile stores secrets in a passphrase encrypted file
+	BackendFile = "file"
+	// BackendKeyring stores secrets in the keyring of the operating system
+	BackendKeyring = "keyring"
+)
+
+var (
+	// ErrNotFound is returned if there is no secret stored for an account
+	ErrNotFound = errors.New("no credentials stored for account")
+	// ErrInvalidPassphrase is returned if the encrypted file can't be decrypted using the provided passphrase
+	ErrInvalidPassphrase = errors.New("invalid passphrase or corrupted credentials file")
+)
+
+// Store persists secrets, e.g. session keys, by account name
+type Store interface {
+	// Get returns the secret of the account, or ErrNotFound
+	Get(account string) (string, error)
+	// Set stores the secret of the account, replacing an existing one
+	Set(account, secret string) error
+	// Delete removes the secret of the account. Deleting a missing secret is not an error.
+	Delete(account string) error
+}
+
+// Reference points to a secret inside a store. It is what gets stored in the config file instead of the secret itself.
+type Reference struct {
+	Backend string
+	Account string
+}
+
+// ParseReference parses a reference in the format '<backend>:<account>'
+func ParseReference(reference string) (Reference, error) {
+	parts := strings.SplitN(reference, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return Reference{}, fmt.Errorf("invalid credentials reference %q, expected '<backend>:<account>'", reference)
+	}
+	if err := ValidateBackend(parts[0]); err != nil {
+		return Reference{}, err
+	}
+	return Reference{Backend: parts[0], Account: parts[1]}, nil
+}
+
+// String formats the reference as '<backend>:<account>'
+func (r Reference) String() string {
+	return fmt.Sprintf("%v:%v", r.Backend, r.Account)
+}
+
+// ValidateBackend checks whether the backend is supported
+func ValidateBackend(backend string) error {
+	switch backend {
+	case BackendFile, BackendKeyring:
+		return nil
+	}
+	return fmt.Errorf("unknown credential store %q, must be one of: %v, %v", backend, BackendFile, BackendKeyring)
+}
@@ -0,0 +1,268 @@
+// Package fakeapi provides a local stand-in for the Kajiwoto GraphQL API.
+// It serves the operations kajitool uses (see query/mutations.go), backed by a query.FakeKajiwotoClient.
+package fakeapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/runtimeracer/go-graphql-client"
+	"github.com/runtimeracer/go-graphql-client/ident"
+	"github.com/runtimeracer/kajitool/query"
+	"github.com/runtimeracer/kajitool/util"
+)
+
+// Operations served, named by their top-level GraphQL field
+const (
+	operationLogin              = "login"
+	operationLoginWithToken     = "loginWithToken"
+	operationAITrainerGroup     = "aiTrainerGroup"
+	operationAITrainerGroupList = "aiTrainerGroupList"
+	operationAITrainedList      = "aiTrainedList"
+	operationTrainDataset       = "trainDataset"
+)
+
+// Server handles GraphQL requests against a fake API.
+// If a store path is set, the state of the fake is saved after each changing request.
+type Server struct {
+	client    *query.FakeKajiwotoClient
+	storePath string
+	mu        sync.Mutex
+}
+
+// NewServer creates a server for the specified fake. storePath may be empty for an in-memory only server.
+func NewServer(client *query.FakeKajiwotoClient, storePath string) *Server {
+	return &Server{
+		client:    client,
+		storePath: storePath,
+	}
+}
+
+// LoadStore creates a fake from a JSON store file. Returns an empty fake if the file doesn't exist yet.
+func LoadStore(path string) (client *query.FakeKajiwotoClient, exists bool, err error) {
+	client = query.NewFakeKajiwotoClient()
+
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return client, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+
+	state := query.FakeState{}
+	if err = json.Unmarshal(content, &state); err != nil {
+		return nil, true, fmt.Errorf("invalid store %v: %v", path, err)
+	}
+	client.SetState(state)
+	return client, true, nil
+}
+
+// SaveStore writes the state of a fake into a JSON store file
+func SaveStore(path string, client *query.FakeKajiwotoClient) error {
+	content, err := json.MarshalIndent(client.State(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(path, func(file io.Writer) error {
+		_, errWrite := file.Write(content)
+		return errWrite
+	})
+}
+
+// graphQLRequest is the body of a GraphQL request
+type graphQLRequest struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables"`
+}
+
+// graphQLError is a single error of a GraphQL response
+type graphQLError struct {
+	Message string `json:"message"`
+}
+
+// ServeHTTP handles a single GraphQL request
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST requests are supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	request := graphQLRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	data, changed, err := s.execute(r.Context(), request, r.Header.Get("auth_token"))
+	if err == nil && changed && s.storePath != "" {
+		s.mu.Lock()
+		err = SaveStore(s.storePath, s.client)
+		s.mu.Unlock()
+	}
+
+	response := make(map[string]interface{})
+	if err != nil {
+		response["data"] = nil
+		response["errors"] = []graphQLError{{Message: err.Error()}}
+	} else {
+		response["data"] = data
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if errEncode := json.NewEncoder(w).Encode(response); errEncode != nil {
+		fmt.Println(fmt.Sprintf("Warn: unable to write response: %v", errEncode))
+	}
+}
+
+// execute performs the operation of a request against the fake.
+// Also tells whether the state of the fake has been changed.
+func (s *Server) execute(ctx context.Context, request graphQLRequest, authToken string) (data map[string]interface{}, changed bool, err error) {
+	vars := request.Variables
+	switch operation := operationName(request.Query); operation {
+	case operationLogin:
+		result, errLogin := s.client.DoLoginUserPW(ctx, stringVar(vars, "usernameOrEmail"), stringVar(vars, "password"))
+		if errLogin != nil {
+			return nil, false, errLogin
+		}
+		return map[string]interface{}{"login": encode(result.Login), "welcome": encode(result.Welcome)}, true, nil
+
+	case operationLoginWithToken:
+		result, errLogin := s.client.DoLoginAuthToken(ctx, stringVar(vars, "authToken"))
+		if errLogin != nil {
+			return nil, false, errLogin
+		}
+		return map[string]interface{}{operation: encode(result.Login), "welcome": encode(result.Welcome)}, false, nil
+
+	case operationAITrainerGroup:
+		result, errQuery := s.client.GetAITrainerGroup(ctx, stringVar(vars, "aiTrainerGroupId"), authToken)
+		if errQuery != nil {
+			return nil, false, errQuery
+		}
+		return map[string]interface{}{operation: encode(result)}, false, nil
+
+	case operationAITrainerGroupList:
+		result, errQuery := s.client.GetAITrainerGroupList(ctx, stringVar(vars, "userId"), authToken,
+			intVar(vars, "limit"), intVar(vars, "page"))
+		if errQuery != nil {
+			return nil, false, errQuery
+		}
+		return map[string]interface{}{operation: encode(result)}, false, nil
+
+	case operationAITrainedList:
+		result, errQuery := s.client.GetAITrainedList(ctx, stringVar(vars, "aiTrainerGroupId"), stringVar(vars, "searchQuery"),
+			authToken, intVar(vars, "limit"), intVar(vars, "page"))
+		if errQuery != nil {
+			return nil, false, errQuery
+		}
+		return map[string]interface{}{operation: encode(result)}, false, nil
+
+	case operationTrainDataset:
+		training, errParse := parseTraining(vars)
+		if errParse != nil {
+			return nil, false, errParse
+		}
+		train := s.client.DoTrainDataset
+		if multi, _ := vars["multi"].(bool); multi {
+			train = s.client.DoTrainDatasetMulti
+		}
+		result, errTrain := train(ctx, stringVar(vars, "aiTrainerGroupId"), authToken, training)
+		if errTrain != nil {
+			return nil, false, errTrain
+		}
+		return map[string]interface{}{operation: encode(result)}, true, nil
+
+	default:
+		return nil, false, fmt.Errorf("unsupported operation '%v'", operation)
+	}
+}
+
+// operationName returns the name of the top-level field of a query, e.g. "login" for mutation(...){login(...){...}}
+func operationName(queryString string) string {
+	start := strings.Index(queryString, "{")
+	if start < 0 {
+		return ""
+	}
+	name := strings.TrimSpace(queryString[start+1:])
+	if end := strings.IndexAny(name, " ({},"); end >= 0 {
+		name = name[:end]
+	}
+	return name
+}
+
+// parseTraining converts the questions and form variables of a trainDataset request into trainings
+func parseTraining(vars map[string]interface{}) ([]query.AITraining, error) {
+	questions, _ := vars["questions"].([]interface{})
+	form, _ := vars["form"].([]interface{})
+	if len(questions) == 0 || len(questions) != len(form) {
+		return nil, errors.New("questions and form must be non-empty and of the same length")
+	}
+
+	training := make([]query.AITraining, len(questions))
+	for i := range questions {
+		question, _ := questions[i].(string)
+		row, _ := form[i].([]interface{})
+		if len(row) != 2 {
+			return nil, fmt.Errorf("invalid form row %v", i)
+		}
+		condition, _ := row[0].(string)
+		message, _ := row[1].(string)
+		training[i] = query.AITraining{
+			Condition:   graphql.String(condition),
+			UserMessage: graphql.String(question),
+			Message:     graphql.String(message),
+		}
+	}
+	return training, nil
+}
+
+func stringVar(vars map[string]interface{}, name string) string {
+	value, _ := vars[name].(string)
+	return value
+}
+
+func intVar(vars map[string]interface{}, name string) int {
+	value, _ := vars[name].(float64)
+	return int(value)
+}
+
+// encode converts a result into JSON-ready values, naming struct fields the same way the GraphQL client queries them
+func encode(value interface{}) interface{} {
+	return encodeValue(reflect.ValueOf(value))
+}
+
+func encodeValue(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return encodeValue(v.Elem())
+	case reflect.Struct:
+		result := make(map[string]interface{}, v.NumField())
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			result[ident.ParseMixedCaps(field.Name).ToLowerCamelCase()] = encodeValue(v.Field(i))
+		}
+		return result
+	case reflect.Slice, reflect.Array:
+		result := make([]interface{}, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			result[i] = encodeValue(v.Index(i))
+		}
+		return result
+	default:
+		return v.Interface()
+	}
+}
@@ -0,0 +1,263 @@
+package fakeapi
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/runtimeracer/kajitool/query"
+)
+
+func TestServerWithGraphQLClient(t *testing.T) {
+	fake := query.NewFakeKajiwotoClient()
+	fake.AddUser(query.User{ID: "user-1", Username: "tester", DisplayName: "Tester"}, "secret")
+	fake.AddDataset(query.AITrainerGroup{ID: "ds1", Name: "Test", User: query.User{ID: "user-1"}},
+		[]query.AITrained{{UserMessage: "Hello", Message: "Hi", ASM: "none", Condition: "00200"}})
+
+	storePath := filepath.Join(t.TempDir(), "store.json")
+	server := httptest.NewServer(NewServer(fake, storePath))
+	defer server.Close()
+
+	// Use the real client against the fake server
+	ctx := context.Background()
+	client, err := query.GetKajiwotoClientWithOptions(server.URL, query.ClientOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	login, err := client.DoLoginUserPW(ctx, "tester", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if login.Login.AuthToken == "" || login.Login.User.DisplayName != "Tester" {
+		t.Fatalf("unexpected login result: %+v", login.Login)
+	}
+	authToken := login.Login.AuthToken
+
+	if login, err = client.DoLoginAuthToken(ctx, authToken); err != nil || login.Login.AuthToken != authToken {
+		t.Fatalf("login via auth token failed: %v", err)
+	}
+
+	group, err := client.GetAITrainerGroup(ctx, "ds1", authToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if group.Name != "Test" || group.Count != 1 || group.User.ID != "user-1" {
+		t.Errorf("unexpected dataset info: %+v", group)
+	}
+
+	groups, err := client.GetAITrainerGroupList(ctx, "user-1", authToken, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || groups[0].ID != "ds1" {
+		t.Errorf("unexpected dataset list: %+v", groups)
+	}
+
+	result, err := client.DoTrainDataset(ctx, "ds1", authToken, []query.AITraining{
+		{UserMessage: "How are you?", Message: "Fine", Condition: "HAPPY##0020##0##0"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Count != 2 {
+		t.Errorf("expected count 2 after training, got %v", result.Count)
+	}
+
+	entries, err := client.GetAITrainedList(ctx, "ds1", "", authToken, 100, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[1].ASM != "HAPPY" || entries[1].Condition != "00200" {
+		t.Errorf("unexpected entries: %+v", entries)
+	}
+
+	if _, err = client.GetAITrainerGroup(ctx, "unknown", authToken); err == nil {
+		t.Error("expected error for unknown dataset")
+	}
+
+	// Training must have been persisted
+	stored, exists, err := LoadStore(storePath)
+	if err != nil || !exists {
+		t.Fatalf("unable to load store: %v", err)
+	}
+	if count := len(stored.GetDatasetEntries("ds1")); count != 2 {
+		t.Errorf("expected 2 stored entries, got %v", count)
+	}
+}
+
+// failingHandler fails the first requests with the specified status code before passing them on
+type failingHandler struct {
+	handler    http.Handler
+	failures   int
+	statusCode int
+	requests   int
+}
+
+func (h *failingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.requests++
+	if h.failures > 0 {
+		h.failures--
+		http.Error(w, http.StatusText(h.statusCode), h.statusCode)
+		return
+	}
+	h.handler.ServeHTTP(w, r)
+}
+
+func TestClientRetries(t *testing.T) {
+	fake := query.NewFakeKajiwotoClient()
+	authToken := fake.AddUser(query.User{ID: "user-1", Username: "tester"}, "secret")
+	fake.AddDataset(query.AITrainerGroup{ID: "ds1", Name: "Test", User: query.User{ID: "user-1"}}, nil)
+
+	handler := &failingHandler{handler: NewServer(fake, filepath.Join(t.TempDir(), "store.json"))}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	ctx := context.Background()
+	retries := 0
+	client, err := query.GetKajiwotoClientWithOptions(server.URL, query.ClientOptions{
+		MaxRetries:     3,
+		RetryBaseDelay: time.Millisecond,
+		RetryMaxDelay:  time.Millisecond,
+		OnRetry: func(attempt int, delay time.Duration, err error) {
+			retries++
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Queries are retried on server errors
+	handler.failures, handler.statusCode = 2, http.StatusBadGateway
+	if _, err := client.GetAITrainerGroup(ctx, "ds1", authToken); err != nil {
+		t.Fatalf("expected query to succeed after retries: %v", err)
+	}
+	if retries != 2 {
+		t.Errorf("expected 2 retries, got %v", retries)
+	}
+
+	// Retries are limited
+	handler.failures, handler.requests = 10, 0
+	if _, err := client.GetAITrainerGroup(ctx, "ds1", authToken); err == nil {
+		t.Error("expected query to fail after exceeding max retries")
+	}
+	if handler.requests != 4 {
+		t.Errorf("expected 4 requests, got %v", handler.requests)
+	}
+
+	// Trainings are retried if the server rejected them...
+	training := []query.AITraining{{UserMessage: "Hello", Message: "Hi", Condition: "##0020##0##0"}}
+	handler.failures, handler.statusCode = 1, http.StatusServiceUnavailable
+	if _, err := client.DoTrainDataset(ctx, "ds1", authToken, training); err != nil {
+		t.Fatalf("expected training to succeed after retry: %v", err)
+	}
+
+	// ...but not on errors which might have happened after processing them
+	handler.failures, handler.statusCode, handler.requests = 1, http.StatusInternalServerError, 0
+	if _, err := client.DoTrainDataset(ctx, "ds1", authToken, training); err == nil || errors.Is(err, query.ErrTrainingRejected) {
+		t.Errorf("expected training to fail without retry and not to be reported as rejected, got %v", err)
+	}
+	if handler.requests != 1 {
+		t.Errorf("expected 1 training request, got %v", handler.requests)
+	}
+
+	// Trainings answered with GraphQL errors haven't been processed
+	if _, err := client.DoTrainDatasetMulti(ctx, "unknown", authToken, training); !errors.Is(err, query.ErrTrainingRejected) {
+		t.Errorf("expected training to be reported as rejected, got %v", err)
+	}
+}
+
+func TestClientTransport(t *testing.T) {
+	fake := query.NewFakeKajiwotoClient()
+	fake.AddUser(query.User{ID: "user-1", Username: "tester"}, "secret")
+
+	userAgent := ""
+	fakeServer := NewServer(fake, filepath.Join(t.TempDir(), "store.json"))
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.Header.Get("User-Agent")
+		fakeServer.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	ctx := context.Background()
+
+	// The certificate of the server is unknown without the CA bundle
+	options := query.DefaultClientOptions()
+	options.RequestsPerSecond = 0
+	options.MaxRetries = 0
+	client, err := query.GetKajiwotoClientWithOptions(server.URL, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.DoLoginUserPW(ctx, "tester", "secret"); err == nil {
+		t.Error("expected request to fail without CA bundle")
+	}
+
+	caBundle := filepath.Join(t.TempDir(), "ca.pem")
+	certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err = ioutil.WriteFile(caBundle, certificate, 0644); err != nil {
+		t.Fatal(err)
+	}
+	options.CABundle = caBundle
+	if client, err = query.GetKajiwotoClientWithOptions(server.URL, options); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.DoLoginUserPW(ctx, "tester", "secret"); err != nil {
+		t.Fatalf("expected request to succeed with CA bundle: %v", err)
+	}
+	if !strings.HasPrefix(userAgent, "kajitool/") {
+		t.Errorf("unexpected user agent %q", userAgent)
+	}
+
+	// Invalid settings are reported
+	options.CABundle = filepath.Join(t.TempDir(), "missing.pem")
+	if _, err = query.GetKajiwotoClientWithOptions(server.URL, options); err == nil {
+		t.Error("expected error for missing CA bundle")
+	}
+	options.CABundle = ""
+	options.Proxy = "not a proxy"
+	if _, err = query.GetKajiwotoClientWithOptions(server.URL, options); err == nil {
+		t.Error("expected error for invalid proxy URL")
+	}
+}
+
+func TestClientProxyAndTimeout(t *testing.T) {
+	fake := query.NewFakeKajiwotoClient()
+	fake.AddUser(query.User{ID: "user-1", Username: "tester"}, "secret")
+	fakeServer := NewServer(fake, filepath.Join(t.TempDir(), "store.json"))
+
+	// A plain HTTP proxy receives the requests with the absolute URL of the target
+	proxied := ""
+	delay := time.Duration(0)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		time.Sleep(delay)
+		fakeServer.ServeHTTP(w, r)
+	}))
+	defer proxy.Close()
+	ctx := context.Background()
+
+	options := query.ClientOptions{Proxy: proxy.URL, Timeout: 100 * time.Millisecond}
+	client, err := query.GetKajiwotoClientWithOptions("http://kajiwoto.invalid/graphql", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.DoLoginUserPW(ctx, "tester", "secret"); err != nil {
+		t.Fatalf("expected request via proxy to succeed: %v", err)
+	}
+	if proxied != "http://kajiwoto.invalid/graphql" {
+		t.Errorf("unexpected proxied URL %q", proxied)
+	}
+
+	// Hung requests time out
+	delay = 300 * time.Millisecond
+	if _, err = client.DoLoginUserPW(ctx, "tester", "secret"); err == nil {
+		t.Error("expected request to time out")
+	}
+}
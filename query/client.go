@@ -2,11 +2,19 @@ package query
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"github.com/runtimeracer/go-graphql-client"
+	"io/ioutil"
 	"net/http"
+	"net/url"
 )
 
+// ErrTrainingRejected is returned if the server refused a training request without processing it
+var ErrTrainingRejected = errors.New("training rejected")
+
 // headerTransport is used to add custom headers to the request
 // shootout to tgwizard; https://github.com/shurcooL/graphql/issues/28
 type headerTransport struct {
@@ -32,28 +40,103 @@ func (h *headerTransport) AddHeaders(newHeaders map[string]string) {
 	}
 }
 
+// KajiwotoClient describes all requests available against the Kajiwoto API.
+// Once ctx is cancelled, no further requests are sent; a request already sent is finished.
+type KajiwotoClient interface {
+	// DoLoginUserPW performs login via user / pw combination
+	DoLoginUserPW(ctx context.Context, username, password string) (LoginResult, error)
+	// DoLoginAuthToken performs login via session key
+	DoLoginAuthToken(ctx context.Context, authToken string) (LoginResult, error)
+	// GetAITrainerGroup fetches info on a dataset
+	GetAITrainerGroup(ctx context.Context, aiTrainerGroupID, authToken string) (AITrainerGroup, error)
+	// GetAITrainerGroupList fetches a page of the datasets owned by a user
+	GetAITrainerGroupList(ctx context.Context, userID, authToken string, limit, page int) ([]AITrainerGroup, error)
+	// GetAITrainedList fetches a page of dataset entries
+	GetAITrainedList(ctx context.Context, aiTrainerGroupID, searchQuery, authToken string, limit, page int) ([]AITrained, error)
+	// DoTrainDataset adds training data to a dataset. Multiple trainings are treated as a dialog.
+	DoTrainDataset(ctx context.Context, aiTrainerGroupID, authToken string, training []AITraining) (TrainDatasetResult, error)
+	// DoTrainDatasetMulti adds training data to a dataset. Multiple trainings are treated as independent entries.
+	DoTrainDatasetMulti(ctx context.Context, aiTrainerGroupID, authToken string, training []AITraining) (TrainDatasetResult, error)
+}
+
 // kajiwotoClient is a custom graphql client for kajiwoto reqeusts
 type kajiwotoClient struct {
 	client          *graphql.Client
 	transportClient *http.Client
+	options         ClientOptions
+	limiter         *rateLimiter
 }
 
-func GetKajiwotoClient(endpoint string) *kajiwotoClient {
+// GetKajiwotoClient creates a client performing requests against the specified GraphQL endpoint
+func GetKajiwotoClient(endpoint string) (KajiwotoClient, error) {
+	return GetKajiwotoClientWithOptions(endpoint, DefaultClientOptions())
+}
+
+// GetKajiwotoClientWithOptions creates a client performing requests against the specified GraphQL endpoint,
+// using custom transport, rate limit and retry settings
+func GetKajiwotoClientWithOptions(endpoint string, options ClientOptions) (KajiwotoClient, error) {
+	// Init Transport
+	base, err := newBaseTransport(options)
+	if err != nil {
+		return nil, err
+	}
+	headers := map[string]string{
+		// Default Headers
+		"Content-Type": "application/json",
+	}
+	if options.UserAgent != "" {
+		headers["User-Agent"] = options.UserAgent
+	}
+
 	// Init HTTP Client
 	transportClient := &http.Client{
 		Transport: &headerTransport{
-			base: http.DefaultTransport,
-			headers: map[string]string{
-				// Default Headers
-				"Content-Type": "application/json",
-			},
+			base:    base,
+			headers: headers,
 		},
+		Timeout: options.Timeout,
 	}
 
 	return &kajiwotoClient{
 		client:          graphql.NewClient(endpoint, transportClient),
 		transportClient: transportClient,
+		options:         options,
+		limiter:         newRateLimiter(options.RequestsPerSecond),
+	}, nil
+}
+
+// newBaseTransport creates the transport performing the HTTP requests, using the proxy and CA bundle of the options
+func newBaseTransport(options ClientOptions) (http.RoundTripper, error) {
+	if options.Proxy == "" && options.CABundle == "" {
+		return http.DefaultTransport, nil
 	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if options.Proxy != "" {
+		proxyURL, err := url.Parse(options.Proxy)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL %q", options.Proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if options.CABundle != "" {
+		content, err := ioutil.ReadFile(options.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read CA bundle: %v", err)
+		}
+		// Trust the bundle in addition to the system certificates
+		certPool, err := x509.SystemCertPool()
+		if err != nil || certPool == nil {
+			certPool = x509.NewCertPool()
+		}
+		if !certPool.AppendCertsFromPEM(content) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %v", options.CABundle)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: certPool}
+	}
+
+	return transport, nil
 }
 
 func (c *kajiwotoClient) GetHeaders() map[string]string {
@@ -65,7 +148,7 @@ func (c *kajiwotoClient) AddHeaders(newHeaders map[string]string) {
 }
 
 // DoLoginUserPW performs login via user / pw combination
-func (c *kajiwotoClient) DoLoginUserPW(username, password string) (result LoginResult, err error) {
+func (c *kajiwotoClient) DoLoginUserPW(ctx context.Context, username, password string) (result LoginResult, err error) {
 	// Sanity check
 	if username == "" || password == "" {
 		return result, fmt.Errorf("invalid login credentials")
@@ -77,7 +160,7 @@ func (c *kajiwotoClient) DoLoginUserPW(username, password string) (result LoginR
 	}
 
 	loginResult := kajiwotoLoginUserPWMutation{}
-	if errLogin := c.performGraphMutation(vars, &loginResult); errLogin != nil {
+	if errLogin := c.performGraphMutation(ctx, vars, &loginResult); errLogin != nil {
 		return result, errLogin
 	}
 
@@ -91,7 +174,7 @@ func (c *kajiwotoClient) DoLoginUserPW(username, password string) (result LoginR
 }
 
 // DoLoginAuthToken performs login via session key if available
-func (c *kajiwotoClient) DoLoginAuthToken(authToken string) (result LoginResult, err error) {
+func (c *kajiwotoClient) DoLoginAuthToken(ctx context.Context, authToken string) (result LoginResult, err error) {
 	// Sanity check
 	if authToken == "" {
 		return result, fmt.Errorf("invalid login credentials")
@@ -109,7 +192,7 @@ func (c *kajiwotoClient) DoLoginAuthToken(authToken string) (result LoginResult,
 	c.AddHeaders(headers)
 
 	loginResult := kajiwotoLoginAuthTokenMutation{}
-	if errLogin := c.performGraphMutation(vars, &loginResult); errLogin != nil {
+	if errLogin := c.performGraphMutation(ctx, vars, &loginResult); errLogin != nil {
 		return result, fmt.Errorf("unable to login, response: %q", errLogin)
 	}
 
@@ -122,7 +205,7 @@ func (c *kajiwotoClient) DoLoginAuthToken(authToken string) (result LoginResult,
 	return result, nil
 }
 
-func (c *kajiwotoClient) GetAITrainerGroup(aiTrainerGroupID, authToken string) (result AITrainerGroup, err error) {
+func (c *kajiwotoClient) GetAITrainerGroup(ctx context.Context, aiTrainerGroupID, authToken string) (result AITrainerGroup, err error) {
 	// Sanity check
 	if authToken == "" {
 		return result, fmt.Errorf("invalid auth token")
@@ -143,7 +226,7 @@ func (c *kajiwotoClient) GetAITrainerGroup(aiTrainerGroupID, authToken string) (
 
 	// Execute Query
 	aiTrainerGroupResult := kajiwotoDatasetAITrainerGroupQuery{}
-	if errLogin := c.performGraphQuery(vars, &aiTrainerGroupResult); errLogin != nil {
+	if errLogin := c.performGraphQuery(ctx, vars, &aiTrainerGroupResult); errLogin != nil {
 		return result, fmt.Errorf("unable to fetch AI trainer group, response: %q", errLogin)
 	}
 
@@ -152,7 +235,45 @@ func (c *kajiwotoClient) GetAITrainerGroup(aiTrainerGroupID, authToken string) (
 	return result, nil
 }
 
-func (c *kajiwotoClient) GetAITrainedList(aiTrainerGroupID, searchQuery, authToken string, limit, page int) (result []AITrained, err error) {
+func (c *kajiwotoClient) GetAITrainerGroupList(ctx context.Context, userID, authToken string, limit, page int) (result []AITrainerGroup, err error) {
+	// Sanity check
+	if authToken == "" {
+		return result, fmt.Errorf("invalid auth token")
+	}
+	if userID == "" {
+		return result, fmt.Errorf("invalid user ID")
+	}
+	if limit < 1 || limit > 100 {
+		return result, fmt.Errorf("limit exceeds allowed range")
+	}
+	if page < 0 {
+		return result, fmt.Errorf("page cannot be negative")
+	}
+
+	vars := map[string]interface{}{
+		"userId": graphql.String(userID),
+		"limit":  graphql.Int(limit),
+		"page":   graphql.Int(page),
+	}
+
+	// Add Auth-Token header
+	headers := map[string]string{
+		"auth_token": authToken,
+	}
+	c.AddHeaders(headers)
+
+	// Execute Query
+	aiTrainerGroupListResult := kajiwotoDatasetAITrainerGroupListQuery{}
+	if errQuery := c.performGraphQuery(ctx, vars, &aiTrainerGroupListResult); errQuery != nil {
+		return result, fmt.Errorf("unable to fetch AI trainer groups, response: %q", errQuery)
+	}
+
+	// Build generic Result object
+	result = aiTrainerGroupListResult.AITrainerGroupList
+	return result, nil
+}
+
+func (c *kajiwotoClient) GetAITrainedList(ctx context.Context, aiTrainerGroupID, searchQuery, authToken string, limit, page int) (result []AITrained, err error) {
 	// Sanity check
 	if authToken == "" {
 		return result, fmt.Errorf("invalid auth token")
@@ -182,7 +303,7 @@ func (c *kajiwotoClient) GetAITrainedList(aiTrainerGroupID, searchQuery, authTok
 
 	// Execute Query
 	aiTrainedListResult := kajiwotoDatasetAITrainedListQuery{}
-	if errLogin := c.performGraphQuery(vars, &aiTrainedListResult); errLogin != nil {
+	if errLogin := c.performGraphQuery(ctx, vars, &aiTrainedListResult); errLogin != nil {
 		return result, fmt.Errorf("unable to fetch AI trainer group, response: %q", errLogin)
 	}
 
@@ -191,8 +312,17 @@ func (c *kajiwotoClient) GetAITrainedList(aiTrainerGroupID, searchQuery, authTok
 	return result, nil
 }
 
-// DoTrainDataset performs login via session key if available
-func (c *kajiwotoClient) DoTrainDataset(aiTrainerGroupID, authToken string, training []AITraining) (result TrainDatasetResult, err error) {
+// DoTrainDataset trains a single entry, or a dialog if multiple trainings are provided
+func (c *kajiwotoClient) DoTrainDataset(ctx context.Context, aiTrainerGroupID, authToken string, training []AITraining) (result TrainDatasetResult, err error) {
+	return c.doTrainDataset(ctx, aiTrainerGroupID, authToken, training, false)
+}
+
+// DoTrainDatasetMulti trains a batch of independent entries within a single request
+func (c *kajiwotoClient) DoTrainDatasetMulti(ctx context.Context, aiTrainerGroupID, authToken string, training []AITraining) (result TrainDatasetResult, err error) {
+	return c.doTrainDataset(ctx, aiTrainerGroupID, authToken, training, true)
+}
+
+func (c *kajiwotoClient) doTrainDataset(ctx context.Context, aiTrainerGroupID, authToken string, training []AITraining, multi bool) (result TrainDatasetResult, err error) {
 	// Sanity check
 	if authToken == "" {
 		return result, fmt.Errorf("invalid login credentials")
@@ -212,7 +342,7 @@ func (c *kajiwotoClient) DoTrainDataset(aiTrainerGroupID, authToken string, trai
 		"form":             form,
 		"editorType":       graphql.String("web-list"),
 		"detailed":         graphql.Boolean(true),
-		"multi":            graphql.Boolean(false), // FIXME: Does not seem to be used for web-ui. Maybe for batch?
+		"multi":            graphql.Boolean(multi),
 	}
 
 	// Add Auth-Token header
@@ -221,8 +351,15 @@ func (c *kajiwotoClient) DoTrainDataset(aiTrainerGroupID, authToken string, trai
 	}
 	c.AddHeaders(headers)
 
+	// Trainings are only retried if the server didn't process them, to not create duplicates
 	trainingResult := kajiwotoDatasetTrainDatasetMutation{}
-	if errTrain := c.performGraphMutation(vars, &trainingResult); errTrain != nil {
+	errTrain := c.withRetry(ctx, isRejectedError, func(requestCtx context.Context) error {
+		return c.client.Mutate(requestCtx, &trainingResult, vars)
+	})
+	if errTrain != nil {
+		if isRejectedError(errTrain) || isGraphQLError(errTrain) {
+			return result, fmt.Errorf("unable to train dataset, response: %q: %w", errTrain, ErrTrainingRejected)
+		}
 		return result, fmt.Errorf("unable to train dataset, response: %q", errTrain)
 	}
 
@@ -231,12 +368,16 @@ func (c *kajiwotoClient) DoTrainDataset(aiTrainerGroupID, authToken string, trai
 	return result, nil
 }
 
-func (c *kajiwotoClient) performGraphMutation(vars map[string]interface{}, mutation interface{}) error {
-	return c.client.Mutate(context.Background(), mutation, vars)
+func (c *kajiwotoClient) performGraphMutation(ctx context.Context, vars map[string]interface{}, mutation interface{}) error {
+	return c.withRetry(ctx, isTransientError, func(requestCtx context.Context) error {
+		return c.client.Mutate(requestCtx, mutation, vars)
+	})
 }
 
-func (c *kajiwotoClient) performGraphQuery(vars map[string]interface{}, query interface{}) error {
-	return c.client.Query(context.Background(), query, vars)
+func (c *kajiwotoClient) performGraphQuery(ctx context.Context, vars map[string]interface{}, query interface{}) error {
+	return c.withRetry(ctx, isTransientError, func(requestCtx context.Context) error {
+		return c.client.Query(requestCtx, query, vars)
+	})
 }
 
 // cloneRequest creates a shallow copy of the request along with a deep copy of the Headers.
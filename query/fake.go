@@ -0,0 +1,416 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/runtimeracer/go-graphql-client"
+)
+
+// Ensure the fake stays in line with the interface
+var _ KajiwotoClient = (*FakeKajiwotoClient)(nil)
+
+// FakeKajiwotoClient is an in-memory implementation of KajiwotoClient.
+// It simulates users, datasets, paging and training without performing any requests.
+type FakeKajiwotoClient struct {
+	mu       sync.Mutex
+	users    map[string]fakeUser
+	sessions map[string]string
+	datasets map[string]*fakeDataset
+	nextID   int
+
+	// TrainingRequests holds all trainings received via DoTrainDataset and DoTrainDatasetMulti, one element per request
+	TrainingRequests [][]AITraining
+	// MultiRequests holds the amount of successful requests received via DoTrainDatasetMulti
+	MultiRequests int
+	// RejectMulti makes DoTrainDatasetMulti fail, to simulate a server not supporting batches
+	RejectMulti bool
+	// RejectTrainerGroupList makes GetAITrainerGroupList fail, to simulate a server not supporting it
+	RejectTrainerGroupList bool
+	// FailMultiAfterTraining makes DoTrainDatasetMulti fail after adding the trainings, to simulate a lost response
+	FailMultiAfterTraining bool
+}
+
+type fakeUser struct {
+	User     User
+	Password string
+}
+
+type fakeDataset struct {
+	Info    AITrainerGroup
+	Entries []AITrained
+}
+
+// NewFakeKajiwotoClient creates an empty fake client
+func NewFakeKajiwotoClient() *FakeKajiwotoClient {
+	return &FakeKajiwotoClient{
+		users:            make(map[string]fakeUser),
+		sessions:         make(map[string]string),
+		datasets:         make(map[string]*fakeDataset),
+		TrainingRequests: make([][]AITraining, 0),
+	}
+}
+
+// AddUser registers a user which is able to login with the specified password.
+// Returns a valid session key for the user.
+func (c *FakeKajiwotoClient) AddUser(user User, password string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.users[string(user.Username)] = fakeUser{User: user, Password: password}
+	return c.createSession(string(user.Username))
+}
+
+// AddDataset registers a dataset along with its entries.
+// Entries without an ID get a generated one; the count of the dataset is updated.
+func (c *FakeKajiwotoClient) AddDataset(info AITrainerGroup, entries []AITrained) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	dataset := &fakeDataset{Info: info, Entries: make([]AITrained, 0, len(entries))}
+	for _, entry := range entries {
+		if entry.ID == "" {
+			entry.ID = graphql.String(c.generateID(string(info.ID)))
+		}
+		entry.AITrainerGroupID = info.ID
+		dataset.Entries = append(dataset.Entries, entry)
+	}
+	dataset.Info.Count = graphql.Int(len(dataset.Entries))
+	c.datasets[string(info.ID)] = dataset
+}
+
+// GetDatasetEntries returns a copy of all entries of a dataset
+func (c *FakeKajiwotoClient) GetDatasetEntries(aiTrainerGroupID string) []AITrained {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	dataset, ok := c.datasets[aiTrainerGroupID]
+	if !ok {
+		return nil
+	}
+	result := make([]AITrained, len(dataset.Entries))
+	copy(result, dataset.Entries)
+	return result
+}
+
+// ExpireSessions invalidates all session keys issued so far
+func (c *FakeKajiwotoClient) ExpireSessions() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sessions = make(map[string]string)
+}
+
+// DoLoginUserPW performs login via user / pw combination
+func (c *FakeKajiwotoClient) DoLoginUserPW(ctx context.Context, username, password string) (result LoginResult, err error) {
+	if err = ctx.Err(); err != nil {
+		return result, err
+	}
+	// Sanity check
+	if username == "" || password == "" {
+		return result, fmt.Errorf("invalid login credentials")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	user, ok := c.users[username]
+	if !ok || user.Password != password {
+		return result, fmt.Errorf("invalid username or password")
+	}
+
+	result.Login.AuthToken = c.createSession(username)
+	result.Login.User = user.User
+	return result, nil
+}
+
+// DoLoginAuthToken performs login via session key.
+// Same as the API, an unknown session key results in an empty auth token instead of an error.
+func (c *FakeKajiwotoClient) DoLoginAuthToken(ctx context.Context, authToken string) (result LoginResult, err error) {
+	if err = ctx.Err(); err != nil {
+		return result, err
+	}
+	// Sanity check
+	if authToken == "" {
+		return result, fmt.Errorf("invalid login credentials")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if username, ok := c.sessions[authToken]; ok {
+		result.Login.AuthToken = authToken
+		result.Login.User = c.users[username].User
+	}
+	return result, nil
+}
+
+func (c *FakeKajiwotoClient) GetAITrainerGroup(ctx context.Context, aiTrainerGroupID, authToken string) (result AITrainerGroup, err error) {
+	if err = ctx.Err(); err != nil {
+		return result, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	dataset, err := c.getDataset(aiTrainerGroupID, authToken)
+	if err != nil {
+		return result, fmt.Errorf("unable to fetch AI trainer group, response: %q", err)
+	}
+	return dataset.Info, nil
+}
+
+// GetAITrainerGroupList returns a page of the datasets owned by a user, ordered by ID.
+// Fails if RejectTrainerGroupList is set.
+func (c *FakeKajiwotoClient) GetAITrainerGroupList(ctx context.Context, userID, authToken string, limit, page int) (result []AITrainerGroup, err error) {
+	if err = ctx.Err(); err != nil {
+		return result, err
+	}
+	if c.RejectTrainerGroupList {
+		return result, fmt.Errorf("unable to fetch AI trainer groups, response: %q", "Cannot query field \"aiTrainerGroupList\" on type \"Query\".")
+	}
+	// Sanity check
+	if limit < 1 || limit > 100 {
+		return result, fmt.Errorf("limit exceeds allowed range")
+	}
+	if page < 0 {
+		return result, fmt.Errorf("page cannot be negative")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.sessions[authToken]; !ok {
+		return result, fmt.Errorf("unable to fetch AI trainer groups, response: %q", "invalid auth token")
+	}
+
+	owned := make([]AITrainerGroup, 0)
+	for _, dataset := range c.datasets {
+		if string(dataset.Info.User.ID) == userID {
+			owned = append(owned, dataset.Info)
+		}
+	}
+	sort.Slice(owned, func(i, j int) bool {
+		return owned[i].ID < owned[j].ID
+	})
+
+	result = make([]AITrainerGroup, 0, limit)
+	for i := page * limit; i < len(owned) && i < (page+1)*limit; i++ {
+		result = append(result, owned[i])
+	}
+	return result, nil
+}
+
+func (c *FakeKajiwotoClient) GetAITrainedList(ctx context.Context, aiTrainerGroupID, searchQuery, authToken string, limit, page int) (result []AITrained, err error) {
+	if err = ctx.Err(); err != nil {
+		return result, err
+	}
+	// Sanity check
+	if limit < 1 || limit > 100 {
+		return result, fmt.Errorf("limit exceeds allowed range")
+	}
+	if page < 0 {
+		return result, fmt.Errorf("page cannot be negative")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	dataset, err := c.getDataset(aiTrainerGroupID, authToken)
+	if err != nil {
+		return result, fmt.Errorf("unable to fetch AI trainer group, response: %q", err)
+	}
+
+	// Filter and page
+	matching := make([]AITrained, 0)
+	for _, entry := range dataset.Entries {
+		if searchQuery == "" ||
+			strings.Contains(string(entry.UserMessage), searchQuery) ||
+			strings.Contains(string(entry.Message), searchQuery) {
+			matching = append(matching, entry)
+		}
+	}
+	result = make([]AITrained, 0, limit)
+	for i := page * limit; i < len(matching) && i < (page+1)*limit; i++ {
+		result = append(result, matching[i])
+	}
+	return result, nil
+}
+
+// DoTrainDataset adds the trainings to a dataset.
+// Each training after the first one gets the user message of the preceding training as history.
+func (c *FakeKajiwotoClient) DoTrainDataset(ctx context.Context, aiTrainerGroupID, authToken string, training []AITraining) (result TrainDatasetResult, err error) {
+	if err = ctx.Err(); err != nil {
+		return result, err
+	}
+	return c.doTrainDataset(aiTrainerGroupID, authToken, training, false)
+}
+
+// DoTrainDatasetMulti adds the trainings to a dataset as independent entries.
+// Fails if RejectMulti or FailMultiAfterTraining is set.
+func (c *FakeKajiwotoClient) DoTrainDatasetMulti(ctx context.Context, aiTrainerGroupID, authToken string, training []AITraining) (result TrainDatasetResult, err error) {
+	if err = ctx.Err(); err != nil {
+		return result, err
+	}
+	if c.RejectMulti {
+		return result, fmt.Errorf("unable to train dataset, response: %q: %w", "multi training not supported", ErrTrainingRejected)
+	}
+	if result, err = c.doTrainDataset(aiTrainerGroupID, authToken, training, true); err == nil && c.FailMultiAfterTraining {
+		return TrainDatasetResult{}, fmt.Errorf("unable to train dataset, response: %q", io.ErrUnexpectedEOF)
+	}
+	return result, err
+}
+
+func (c *FakeKajiwotoClient) doTrainDataset(aiTrainerGroupID, authToken string, training []AITraining, multi bool) (result TrainDatasetResult, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	dataset, err := c.getDataset(aiTrainerGroupID, authToken)
+	if err != nil {
+		return result, fmt.Errorf("unable to train dataset, response: %q", err)
+	}
+	if owner, ok := c.users[c.sessions[authToken]]; !ok || owner.User.ID != dataset.Info.User.ID {
+		return result, fmt.Errorf("unable to train dataset, response: %q", "not allowed")
+	}
+
+	c.TrainingRequests = append(c.TrainingRequests, training)
+	if multi {
+		c.MultiRequests++
+	}
+	for i, item := range training {
+		asm, condition, errParse := parseFakeTrainingCondition(string(item.Condition))
+		if errParse != nil {
+			return result, fmt.Errorf("unable to train dataset, response: %q", errParse)
+		}
+
+		history := make([]graphql.String, 0)
+		if i > 0 && !multi {
+			history = append(history, training[i-1].UserMessage)
+		}
+		dataset.Entries = append(dataset.Entries, AITrained{
+			ID:               graphql.String(c.generateID(aiTrainerGroupID)),
+			UserMessage:      item.UserMessage,
+			Message:          item.Message,
+			ASM:              graphql.String(asm),
+			Condition:        graphql.String(condition),
+			History:          history,
+			AITrainerGroupID: dataset.Info.ID,
+		})
+	}
+	dataset.Info.Count = graphql.Int(len(dataset.Entries))
+
+	result.Count = dataset.Info.Count
+	return result, nil
+}
+
+// getDataset returns a dataset if the session key is valid
+func (c *FakeKajiwotoClient) getDataset(aiTrainerGroupID, authToken string) (*fakeDataset, error) {
+	if _, ok := c.sessions[authToken]; !ok {
+		return nil, fmt.Errorf("invalid auth token")
+	}
+	dataset, ok := c.datasets[aiTrainerGroupID]
+	if !ok {
+		return nil, fmt.Errorf("dataset %v not found", aiTrainerGroupID)
+	}
+	return dataset, nil
+}
+
+func (c *FakeKajiwotoClient) createSession(username string) string {
+	c.nextID++
+	authToken := fmt.Sprintf("session-%v", c.nextID)
+	c.sessions[authToken] = username
+	return authToken
+}
+
+func (c *FakeKajiwotoClient) generateID(prefix string) string {
+	c.nextID++
+	return fmt.Sprintf("%v-%v", prefix, c.nextID)
+}
+
+// parseFakeTrainingCondition splits a training condition string (asm##conditions##index##0) into ASM and condition
+func parseFakeTrainingCondition(input string) (asm, condition string, err error) {
+	parts := strings.Split(input, "##")
+	if len(parts) != 4 || len(parts[1]) != 4 {
+		return "", "", fmt.Errorf("invalid training condition '%v'", input)
+	}
+	asm = parts[0]
+	if asm == "" {
+		asm = "none"
+	}
+	return asm, parts[1] + "0", nil
+}
+
+// FakeState is the serializable state of a FakeKajiwotoClient
+type FakeState struct {
+	Users    []FakeStateUser
+	Sessions map[string]string
+	Datasets []FakeStateDataset
+	NextID   int
+}
+
+// FakeStateUser is a user of the fake API along with its password
+type FakeStateUser struct {
+	User     User
+	Password string
+}
+
+// FakeStateDataset is a dataset of the fake API along with its entries
+type FakeStateDataset struct {
+	Info    AITrainerGroup
+	Entries []AITrained
+}
+
+// State returns a copy of the current state of the fake
+func (c *FakeKajiwotoClient) State() FakeState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	state := FakeState{
+		Users:    make([]FakeStateUser, 0, len(c.users)),
+		Sessions: make(map[string]string, len(c.sessions)),
+		Datasets: make([]FakeStateDataset, 0, len(c.datasets)),
+		NextID:   c.nextID,
+	}
+	for _, user := range c.users {
+		state.Users = append(state.Users, FakeStateUser{User: user.User, Password: user.Password})
+	}
+	for authToken, username := range c.sessions {
+		state.Sessions[authToken] = username
+	}
+	for _, dataset := range c.datasets {
+		entries := make([]AITrained, len(dataset.Entries))
+		copy(entries, dataset.Entries)
+		state.Datasets = append(state.Datasets, FakeStateDataset{Info: dataset.Info, Entries: entries})
+	}
+
+	// Keep output stable
+	sort.Slice(state.Users, func(i, j int) bool { return state.Users[i].User.Username < state.Users[j].User.Username })
+	sort.Slice(state.Datasets, func(i, j int) bool { return state.Datasets[i].Info.ID < state.Datasets[j].Info.ID })
+	return state
+}
+
+// SetState replaces the current state of the fake
+func (c *FakeKajiwotoClient) SetState(state FakeState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.users = make(map[string]fakeUser, len(state.Users))
+	c.sessions = make(map[string]string, len(state.Sessions))
+	c.datasets = make(map[string]*fakeDataset, len(state.Datasets))
+	c.nextID = state.NextID
+
+	for _, user := range state.Users {
+		c.users[string(user.User.Username)] = fakeUser{User: user.User, Password: user.Password}
+	}
+	for authToken, username := range state.Sessions {
+		c.sessions[authToken] = username
+	}
+	for _, dataset := range state.Datasets {
+		entries := make([]AITrained, len(dataset.Entries))
+		copy(entries, dataset.Entries)
+		c.datasets[string(dataset.Info.ID)] = &fakeDataset{Info: dataset.Info, Entries: entries}
+	}
+}
@@ -18,6 +18,10 @@ type kajiwotoDatasetAITrainerGroupQuery struct {
 	AITrainerGroup AITrainerGroup `graphql:"aiTrainerGroup (aiTrainerGroupId: $aiTrainerGroupId)"`
 }
 
+type kajiwotoDatasetAITrainerGroupListQuery struct {
+	AITrainerGroupList []AITrainerGroup `graphql:"aiTrainerGroupList (userId: $userId, limit: $limit, page: $page)"`
+}
+
 type kajiwotoDatasetAITrainedListQuery struct {
 	AITrainedList []AITrained `graphql:"aiTrainedList (aiTrainerGroupId: $aiTrainerGroupId, limit: $limit, page: $page, searchQuery: $searchQuery)"`
 }
@@ -0,0 +1,50 @@
+package query
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/runtimeracer/kajitool/constants"
+)
+
+const (
+	DefaultRequestTimeout    = time.Minute
+	DefaultRequestsPerSecond = 1.0
+	DefaultMaxRetries        = 5
+	DefaultRetryBaseDelay    = time.Second
+	DefaultRetryMaxDelay     = 30 * time.Second
+)
+
+// ClientOptions configure the behavior of the Kajiwoto client
+type ClientOptions struct {
+	// Timeout limits the duration of a single request. 0 disables the timeout.
+	Timeout time.Duration
+	// Proxy is the URL of the HTTP(S) proxy to use. If empty, the proxy is taken from the environment (HTTPS_PROXY etc.).
+	Proxy string
+	// CABundle is the path of a PEM file with certificates trusted in addition to the system certificates
+	CABundle string
+	// UserAgent is sent along with each request, if set
+	UserAgent string
+	// RequestsPerSecond limits the rate of requests sent to the API. 0 disables the limit.
+	RequestsPerSecond float64
+	// MaxRetries is the amount of retries for requests failing with a transient error
+	MaxRetries int
+	// RetryBaseDelay is the delay before the first retry. It doubles with each further retry.
+	RetryBaseDelay time.Duration
+	// RetryMaxDelay caps the delay between retries
+	RetryMaxDelay time.Duration
+	// OnRetry is called before a failed request is retried, if set
+	OnRetry func(attempt int, delay time.Duration, err error)
+}
+
+// DefaultClientOptions returns the options used by GetKajiwotoClient
+func DefaultClientOptions() ClientOptions {
+	return ClientOptions{
+		Timeout:           DefaultRequestTimeout,
+		UserAgent:         fmt.Sprintf("%v/%v", constants.KajiToolName, constants.KajiToolVersion),
+		RequestsPerSecond: DefaultRequestsPerSecond,
+		MaxRetries:        DefaultMaxRetries,
+		RetryBaseDelay:    DefaultRetryBaseDelay,
+		RetryMaxDelay:     DefaultRetryMaxDelay,
+	}
+}
@@ -0,0 +1,191 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"github.com/runtimeracer/go-graphql-client"
+	"io"
+	"math/rand"
+	"net"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// statusCodePattern extracts the HTTP status code from errors returned by the graphql client
+var statusCodePattern = regexp.MustCompile(`non-200 OK status code: (\d{3})`)
+
+// transientGraphQLErrors are parts of GraphQL error messages which indicate a temporary problem on the server side
+var transientGraphQLErrors = []string{
+	"internal server error",
+	"service unavailable",
+	"too many requests",
+	"rate limit",
+	"timeout",
+	"timed out",
+	"try again",
+}
+
+// rateLimiter spaces out requests to a fixed interval
+type rateLimiter struct {
+	interval time.Duration
+	mutex    sync.Mutex
+	next     time.Time
+}
+
+// newRateLimiter creates a rate limiter. Returns nil if requestsPerSecond is not positive.
+func newRateLimiter(requestsPerSecond float64) *rateLimiter {
+	if requestsPerSecond <= 0 {
+		return nil
+	}
+	return &rateLimiter{
+		interval: time.Duration(float64(time.Second) / requestsPerSecond),
+	}
+}
+
+// wait blocks until the next request may be sent, or ctx is cancelled
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return ctx.Err()
+	}
+
+	l.mutex.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mutex.Unlock()
+
+	return sleepContext(ctx, delay)
+}
+
+// withRetry performs a request, retrying it with jittered exponential backoff as long as retryable reports the error
+// as transient. All attempts are subject to the rate limit.
+// Once ctx is cancelled, no further attempts are made. The request itself receives a context which isn't cancelled,
+// so a request already sent is finished instead of leaving it unknown whether the server processed it.
+func (c *kajiwotoClient) withRetry(ctx context.Context, retryable func(error) bool, request func(requestCtx context.Context) error) (err error) {
+	for attempt := 0; ; attempt++ {
+		if err = c.limiter.wait(ctx); err != nil {
+			return err
+		}
+		if err = request(detachedContext{parent: ctx}); err == nil || attempt >= c.options.MaxRetries || !retryable(err) {
+			return err
+		}
+
+		delay := backoffDelay(attempt, c.options.RetryBaseDelay, c.options.RetryMaxDelay)
+		if c.options.OnRetry != nil {
+			c.options.OnRetry(attempt+1, delay, err)
+		}
+		if errSleep := sleepContext(ctx, delay); errSleep != nil {
+			return errSleep
+		}
+	}
+}
+
+// sleepContext pauses for the specified duration. Returns early with the context's error if ctx is cancelled.
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	if delay <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// detachedContext keeps the values of its parent, but is never cancelled
+type detachedContext struct {
+	parent context.Context
+}
+
+func (c detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (c detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (c detachedContext) Err() error {
+	return nil
+}
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
+
+// backoffDelay returns the delay before a retry; randomized between half and the full exponential delay
+func backoffDelay(attempt int, base, max time.Duration) time.Duration {
+	delay := base
+	for i := 0; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// isTransientError tells whether a request failed due to a network problem, a server side error or rate limiting
+func isTransientError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	if statusCode := errorStatusCode(err); statusCode > 0 {
+		return statusCode == 429 || statusCode >= 500
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	message := strings.ToLower(err.Error())
+	for _, transient := range transientGraphQLErrors {
+		if strings.Contains(message, transient) {
+			return true
+		}
+	}
+	return false
+}
+
+// isRejectedError tells whether a request has been rejected without being processed by the server.
+// Only these errors are safe to retry for requests which are not idempotent, like trainings.
+func isRejectedError(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	statusCode := errorStatusCode(err)
+	return statusCode == 429 || statusCode == 503
+}
+
+// isGraphQLError tells whether the server answered a request with GraphQL errors instead of processing it
+func isGraphQLError(err error) bool {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if reflect.TypeOf(err).PkgPath() == reflect.TypeOf(graphql.Client{}).PkgPath() {
+			return true
+		}
+	}
+	return false
+}
+
+// errorStatusCode returns the HTTP status code of a failed request, or 0 if unknown
+func errorStatusCode(err error) int {
+	match := statusCodePattern.FindStringSubmatch(err.Error())
+	if match == nil {
+		return 0
+	}
+	statusCode, _ := strconv.Atoi(match[1])
+	return statusCode
+}
@@ -0,0 +1,195 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ErrSessionExpired is returned if a session key is no longer accepted and there is no way to re-authenticate
+var ErrSessionExpired = errors.New("session expired, please login again")
+
+// authErrorMessages are contained in errors of requests rejected due to an invalid or expired session
+var authErrorMessages = []string{
+	"invalid auth token",
+	"invalid session",
+	"session expired",
+	"not authenticated",
+	"unauthenticated",
+	"unauthorized",
+}
+
+// Authenticator logs in again once the session expired, e.g. using stored credentials or by prompting for them
+type Authenticator func(ctx context.Context, client KajiwotoClient) (LoginResult, error)
+
+// SessionClient wraps a KajiwotoClient to refresh expired sessions transparently. Requests rejected due to an
+// expired session are retried once after re-authenticating. Since the session key may change at any time, requests
+// always use the current session key instead of the one passed.
+type SessionClient struct {
+	client       KajiwotoClient
+	authenticate Authenticator
+	onRefresh    func(authToken string) error
+
+	mutex     sync.Mutex
+	authToken string
+}
+
+// NewSessionClient creates a client using the session key authToken. Once it expires, authenticate is used to
+// login again, and onRefresh is called with the new session key, e.g. to persist it. Both functions may be nil.
+func NewSessionClient(client KajiwotoClient, authToken string, authenticate Authenticator, onRefresh func(authToken string) error) *SessionClient {
+	return &SessionClient{
+		client:       client,
+		authenticate: authenticate,
+		onRefresh:    onRefresh,
+		authToken:    authToken,
+	}
+}
+
+// AuthToken returns the current session key
+func (s *SessionClient) AuthToken() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.authToken
+}
+
+// DoLoginUserPW performs login via user / pw combination, and uses the new session for further requests
+func (s *SessionClient) DoLoginUserPW(ctx context.Context, username, password string) (LoginResult, error) {
+	result, err := s.client.DoLoginUserPW(ctx, username, password)
+	if err == nil && result.Login.AuthToken != "" {
+		s.mutex.Lock()
+		s.authToken = result.Login.AuthToken
+		s.mutex.Unlock()
+	}
+	return result, err
+}
+
+// DoLoginAuthToken performs login via the current session key. If the server doesn't accept it anymore,
+// the result of re-authenticating is returned.
+func (s *SessionClient) DoLoginAuthToken(ctx context.Context, authToken string) (LoginResult, error) {
+	authToken = s.currentToken(authToken)
+	if authToken == "" {
+		return s.refresh(ctx, authToken)
+	}
+	result, err := s.client.DoLoginAuthToken(ctx, authToken)
+	if (err == nil && result.Login.AuthToken == "") || IsAuthError(err) {
+		return s.refresh(ctx, authToken)
+	}
+	return result, err
+}
+
+func (s *SessionClient) GetAITrainerGroup(ctx context.Context, aiTrainerGroupID, authToken string) (result AITrainerGroup, err error) {
+	err = s.withSession(ctx, authToken, func(authToken string) (errRequest error) {
+		result, errRequest = s.client.GetAITrainerGroup(ctx, aiTrainerGroupID, authToken)
+		return errRequest
+	})
+	return result, err
+}
+
+func (s *SessionClient) GetAITrainerGroupList(ctx context.Context, userID, authToken string, limit, page int) (result []AITrainerGroup, err error) {
+	err = s.withSession(ctx, authToken, func(authToken string) (errRequest error) {
+		result, errRequest = s.client.GetAITrainerGroupList(ctx, userID, authToken, limit, page)
+		return errRequest
+	})
+	return result, err
+}
+
+func (s *SessionClient) GetAITrainedList(ctx context.Context, aiTrainerGroupID, searchQuery, authToken string, limit, page int) (result []AITrained, err error) {
+	err = s.withSession(ctx, authToken, func(authToken string) (errRequest error) {
+		result, errRequest = s.client.GetAITrainedList(ctx, aiTrainerGroupID, searchQuery, authToken, limit, page)
+		return errRequest
+	})
+	return result, err
+}
+
+// DoTrainDataset adds training data to a dataset. Trainings rejected due to an expired session haven't been
+// processed, so they are safe to retry.
+func (s *SessionClient) DoTrainDataset(ctx context.Context, aiTrainerGroupID, authToken string, training []AITraining) (result TrainDatasetResult, err error) {
+	err = s.withSession(ctx, authToken, func(authToken string) (errRequest error) {
+		result, errRequest = s.client.DoTrainDataset(ctx, aiTrainerGroupID, authToken, training)
+		return errRequest
+	})
+	return result, err
+}
+
+func (s *SessionClient) DoTrainDatasetMulti(ctx context.Context, aiTrainerGroupID, authToken string, training []AITraining) (result TrainDatasetResult, err error) {
+	err = s.withSession(ctx, authToken, func(authToken string) (errRequest error) {
+		result, errRequest = s.client.DoTrainDatasetMulti(ctx, aiTrainerGroupID, authToken, training)
+		return errRequest
+	})
+	return result, err
+}
+
+// withSession performs the request using the current session key. If it fails due to an expired session,
+// the session is refreshed and the request is retried once.
+func (s *SessionClient) withSession(ctx context.Context, authToken string, request func(authToken string) error) error {
+	authToken = s.currentToken(authToken)
+	err := request(authToken)
+	if !IsAuthError(err) {
+		return err
+	}
+
+	result, errRefresh := s.refresh(ctx, authToken)
+	if errRefresh != nil {
+		return errRefresh
+	}
+	return request(result.Login.AuthToken)
+}
+
+// refresh re-authenticates, unless the session has already been refreshed since expiredToken was rejected
+func (s *SessionClient) refresh(ctx context.Context, expiredToken string) (result LoginResult, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.authToken != expiredToken && s.authToken != "" {
+		return s.client.DoLoginAuthToken(ctx, s.authToken)
+	}
+	if s.authenticate == nil {
+		return result, ErrSessionExpired
+	}
+
+	if result, err = s.authenticate(ctx, s.client); err != nil {
+		return result, err
+	}
+	if result.Login.AuthToken == "" {
+		return result, ErrSessionExpired
+	}
+	s.authToken = result.Login.AuthToken
+	if s.onRefresh != nil {
+		if err = s.onRefresh(s.authToken); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// currentToken returns the current session key, or the passed one if there is none yet
+func (s *SessionClient) currentToken(authToken string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.authToken == "" {
+		s.authToken = authToken
+	}
+	return s.authToken
+}
+
+// IsAuthError checks whether the request failed due to an invalid or expired session
+func IsAuthError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ErrSessionExpired) {
+		return true
+	}
+	if statusCode := errorStatusCode(err); statusCode == http.StatusUnauthorized {
+		return true
+	}
+	message := strings.ToLower(err.Error())
+	for _, authMessage := range authErrorMessages {
+		if strings.Contains(message, authMessage) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,11 @@
 package util
 
 import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"sort"
 )
 
@@ -17,3 +22,38 @@ func GetMapKeyIndicesStringString(input map[string]string) []string {
 
 	return keySlice
 }
+
+// WriteFileAtomic writes a file by passing a temporary file in the same directory to write, and renaming it to the
+// target once write succeeded. This way, the target is either written completely or left unchanged.
+func WriteFileAtomic(target string, write func(w io.Writer) error) error {
+	return WriteFileAtomicPerm(target, 0644, write)
+}
+
+// WriteFileAtomicPerm works like WriteFileAtomic, but creates the target with the specified permissions.
+// The temporary file is only readable by the current user until it has been written completely.
+func WriteFileAtomicPerm(target string, perm os.FileMode, write func(w io.Writer) error) (err error) {
+	file, err := ioutil.TempFile(filepath.Dir(target), fmt.Sprintf(".%v.tmp-*", filepath.Base(target)))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = file.Close()
+			_ = os.Remove(file.Name())
+		}
+	}()
+
+	if err = write(file); err != nil {
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(file.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), target)
+}